@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// APICategory is a category as served by the API, decoupled from DbCategory.
+type APICategory struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	AltName       string    `json:"altName"`
+	Image         string    `json:"image"`
+	YearOfRelease string    `json:"yearOfRelease"`
+	Status        string    `json:"status"`
+	Author        string    `json:"author"`
+	Artist        string    `json:"artist"`
+	Description   string    `json:"description"`
+	Genres        []string  `json:"genres"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+type APIChapter struct {
+	ID         int       `json:"id"`
+	CategoryID int       `json:"categoryId"`
+	Name       string    `json:"name"`
+	ChapterNo  int       `json:"chapterNo"`
+	TotalPages int       `json:"totalPages"`
+	ScrappedAt time.Time `json:"scrappedAt"`
+}
+
+type APIPage struct {
+	PageNo int    `json:"pageNo"`
+	Image  string `json:"image"`
+}
+
+type APIGenre struct {
+	Name       string `json:"name"`
+	Categories int    `json:"categories"`
+}
+
+// APIList is a page of results. Total counts every match, not only Items.
+type APIList struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// apiHandler serves the scraped library as JSON. Recording chapter views is
+// the only write.
+func (app *App) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /categories", apiFunc(app.listCategoriesAPI))
+	mux.Handle("GET /categories/{id}", apiFunc(app.getCategoryAPI))
+	mux.Handle("GET /categories/{id}/chapters", apiFunc(app.listChaptersAPI))
+	mux.Handle("GET /chapters/{id}/pages", apiFunc(app.listPagesAPI))
+	mux.Handle("GET /genres", apiFunc(app.listGenresAPI))
+	mux.Handle("POST /chapters/{id}/hits", apiFunc(app.recordHitAPI))
+	mux.Handle("GET /chapters/{id}/hits", apiFunc(app.chapterHitsAPI))
+	mux.Handle("GET /popular", apiFunc(app.popularAPI))
+	mux.Handle("GET /metrics", registry)
+
+	return mux
+}
+
+// apiFunc writes the value returned by fn as json, or its error as
+// {"error": "..."} with the matching status code.
+type apiFunc func(r *http.Request) (interface{}, error)
+
+func (fn apiFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	value, err := fn(r)
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "internal server error"
+
+		if e, ok := err.(*apiError); ok {
+			status, message = e.Status, e.Message
+		} else {
+			slog.Error("api request failed", "method", r.Method, "url", r.URL.String(), "err", err)
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Warn("unable to write api response", "err", err)
+	}
+}
+
+// pagination reads ?limit= and ?offset=.
+func pagination(r *http.Request) (limit int, offset int, err error) {
+	limit, err = intParam(r, "limit", defaultPageLimit)
+	if err != nil {
+		return
+	}
+
+	if limit < 1 || limit > maxPageLimit {
+		return 0, 0, badRequest("limit must be between 1 and %v", maxPageLimit)
+	}
+
+	offset, err = intParam(r, "offset", 0)
+	if err != nil {
+		return
+	}
+
+	if offset < 0 {
+		return 0, 0, badRequest("offset must not be negative")
+	}
+
+	return
+}
+
+func intParam(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, badRequest("%v must be a number, got %q", name, value)
+	}
+
+	return n, nil
+}
+
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		return 0, badRequest("id must be a positive number, got %q", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// listCategoriesAPI serves GET /categories, filtered by ?q= (a substring of
+// the name or alt name), ?genre= and ?status=.
+func (app *App) listCategoriesAPI(r *http.Request) (interface{}, error) {
+	limit, offset, err := pagination(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := app.DB.Model(&DbCategory{})
+
+	if q := r.URL.Query().Get("q"); q != "" {
+		query = query.Where("name ILIKE ? OR alt_name ILIKE ?", "%"+q+"%", "%"+q+"%")
+	}
+
+	if genre := r.URL.Query().Get("genre"); genre != "" {
+		query = query.Where("id IN (SELECT db_category_id FROM db_genre WHERE name = ?)", genre)
+	}
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	var categories []DbCategory
+	if err := query.Preload("Genres").Order("name").Limit(limit).Offset(offset).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]APICategory, 0, len(categories))
+	for _, c := range categories {
+		items = append(items, apiCategory(c))
+	}
+
+	return APIList{Items: items, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+func (app *App) getCategoryAPI(r *http.Request) (interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	category := DbCategory{}
+
+	query := app.DB.Preload("Genres").First(&category, id)
+	if query.RecordNotFound() {
+		return nil, notFound("category %v not found", id)
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+
+	return apiCategory(category), nil
+}
+
+// listChaptersAPI serves GET /categories/{id}/chapters in chapter order,
+// only those after ?after= if it is given.
+func (app *App) listChaptersAPI(r *http.Request) (interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, offset, err := pagination(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.exists(&DbCategory{}, id, "category"); err != nil {
+		return nil, err
+	}
+
+	query := app.DB.Model(&DbChapter{}).Where("db_category_id = ?", id)
+
+	if r.URL.Query().Get("after") != "" {
+		after, err := intParam(r, "after", 0)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("chapter_no > ?", after)
+	}
+
+	var total int
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	var chapters []DbChapter
+	if err := query.Order("chapter_no").Limit(limit).Offset(offset).Find(&chapters).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]APIChapter, 0, len(chapters))
+	for _, c := range chapters {
+		items = append(items, APIChapter{
+			ID:         c.ID,
+			CategoryID: c.DbCategoryID,
+			Name:       c.Name,
+			ChapterNo:  c.ChapterNo,
+			TotalPages: c.TotalPages,
+			ScrappedAt: time.Unix(c.ScrappedTime, 0).UTC(),
+		})
+	}
+
+	return APIList{Items: items, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+// listPagesAPI serves GET /chapters/{id}/pages. A chapter is small enough
+// to be returned whole.
+func (app *App) listPagesAPI(r *http.Request) (interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.exists(&DbChapter{}, id, "chapter"); err != nil {
+		return nil, err
+	}
+
+	var pages []DbPage
+	if err := app.DB.Where("db_chapter_id = ?", id).Order("page_no").Find(&pages).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]APIPage, 0, len(pages))
+	for _, p := range pages {
+		items = append(items, APIPage{PageNo: p.PageNo, Image: p.HostedMangaSrc})
+	}
+
+	return items, nil
+}
+
+func (app *App) listGenresAPI(r *http.Request) (interface{}, error) {
+	genres := make([]APIGenre, 0)
+
+	err := app.DB.Raw("SELECT name, count(DISTINCT db_category_id) AS categories FROM db_genre GROUP BY name ORDER BY name").Scan(&genres).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (app *App) exists(model interface{}, id int, kind string) error {
+	var count int
+
+	if err := app.DB.Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return notFound("%v %v not found", kind, id)
+	}
+
+	return nil
+}
+
+func apiCategory(c DbCategory) APICategory {
+	genres := make([]string, 0, len(c.Genres))
+	for _, g := range c.Genres {
+		genres = append(genres, g.Name)
+	}
+
+	return APICategory{
+		ID:            c.ID,
+		Name:          c.Name,
+		AltName:       c.AltName,
+		Image:         c.HostedCategoryImage,
+		YearOfRelease: c.YearOfRelease,
+		Status:        c.Status,
+		Author:        c.Author,
+		Artist:        c.Artist,
+		Description:   c.Description,
+		Genres:        genres,
+		UpdatedAt:     c.UpdatedAt,
+	}
+}
+
+// serve runs the API on app.Listen until the server fails or ctx is done,
+// then lets open requests finish within app.ShutdownTimeout.
+func (app *App) serve(ctx context.Context) error {
+	server := &http.Server{
+		Addr:         app.Listen,
+		Handler:      app.apiHandler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	slog.Info("serving api", "addr", app.Listen)
+
+	failed := make(chan error, 1)
+	go func() { failed <- server.ListenAndServe() }()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	return server.Shutdown(shutdown)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIRejectsBadRequestsBeforeQuerying(t *testing.T) {
+
+	handler := (&App{}).apiHandler()
+
+	cases := map[string]int{
+		"/categories?limit=0":               http.StatusBadRequest,
+		"/categories?limit=1000":            http.StatusBadRequest,
+		"/categories?offset=-1":             http.StatusBadRequest,
+		"/categories/abc":                   http.StatusBadRequest,
+		"/categories/0/chapters":            http.StatusBadRequest,
+		"/categories/1/chapters?limit=nope": http.StatusBadRequest,
+		"/chapters/x/pages":                 http.StatusBadRequest,
+		"/chapters/1/hits?days=400":         http.StatusBadRequest,
+		"/popular?days=0":                   http.StatusBadRequest,
+		"/popular?limit=x":                  http.StatusBadRequest,
+		"/unknown":                          http.StatusNotFound,
+	}
+
+	for path, status := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		if w.Code != status {
+			t.Error(path, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/chapters/x/hits", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Error(w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/categories", nil))
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error(w.Code)
+	}
+}
+
+func TestAPIFuncErrors(t *testing.T) {
+
+	var body map[string]string
+
+	w := httptest.NewRecorder()
+	apiFunc(func(r *http.Request) (interface{}, error) {
+		return nil, notFound("category %v not found", 7)
+	}).ServeHTTP(w, httptest.NewRequest("GET", "/categories/7", nil))
+
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	if w.Code != http.StatusNotFound || body["error"] != "category 7 not found" {
+		t.Error(w.Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	apiFunc(func(r *http.Request) (interface{}, error) {
+		return nil, errors.New("pq: password authentication failed")
+	}).ServeHTTP(w, httptest.NewRequest("GET", "/genres", nil))
+
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	if w.Code != http.StatusInternalServerError || body["error"] != "internal server error" {
+		t.Error(w.Code, body)
+	}
+}
+
+func TestPagination(t *testing.T) {
+
+	limit, offset, err := pagination(httptest.NewRequest("GET", "/categories", nil))
+
+	if limit != defaultPageLimit || offset != 0 || err != nil {
+		t.Error(limit, offset, err)
+	}
+
+	limit, offset, err = pagination(httptest.NewRequest("GET", "/categories?limit=10&offset=20", nil))
+
+	if limit != 10 || offset != 20 || err != nil {
+		t.Error(limit, offset, err)
+	}
+}
+
+func TestAPICategoryGenres(t *testing.T) {
+
+	result := apiCategory(DbCategory{ID: 3, Name: "Naruto", HostedCategoryImage: "http://img/n.jpg", Genres: []DbGenre{{Name: "Action"}, {Name: "Ninja"}}})
+
+	if result.ID != 3 || result.Image != "http://img/n.jpg" || len(result.Genres) != 2 || result.Genres[1] != "Ninja" {
+		t.Error(result)
+	}
+
+	if result := apiCategory(DbCategory{}); result.Genres == nil {
+		t.Error("genres must encode as [] rather than null")
+	}
+}
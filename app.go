@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// App holds everything a crawl depends on. It is built explicitly by main so
+// that nothing touches the database or the network at package init.
+type App struct {
+	DB      *gorm.DB
+	Clients *ClientPool
+	Source  Source
+	Store   BlobStore
+
+	// PageWorkers is the number of pages of a chapter processed concurrently.
+	PageWorkers int
+	// ChapterWorkers is the number of chapters of a category processed concurrently.
+	ChapterWorkers int
+	// MaxJobAttempts is how many times a chapter may fail before it is moved
+	// to the dead-letter state and no longer picked up.
+	MaxJobAttempts int
+	// LeaseTTL is how long a category lease lasts without a heartbeat. A
+	// crashed instance's categories become available again once it expires.
+	LeaseTTL time.Duration
+	// InstanceID identifies this process as the owner of the leases it holds.
+	InstanceID string
+	// Popularity ranks the categories crawled by -runMode=top.
+	Popularity PopularityProvider
+	// Listen is the address the api is served on.
+	Listen string
+	// MetricsListen is the address crawl commands serve /metrics on, if any.
+	MetricsListen string
+	// GCGrace is how old an unreferenced blob must be for gc-blobs to
+	// delete it.
+	GCGrace time.Duration
+	// GCDryRun makes gc-blobs only log the blobs it would delete.
+	GCDryRun bool
+	// ShutdownTimeout is how long running chapters and api requests may
+	// take to finish after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// chapter workers of the same category must not both create it
+	categoryMu sync.Mutex
+	// lastPopular is the last ranking Popularity returned
+	lastPopular []string
+}
+
+// newApp builds the App described by cfg around an open database.
+func newApp(cfg *Config, db *gorm.DB) (*App, error) {
+	timeouts := FetchTimeouts{
+		Listing: time.Duration(cfg.Timeouts.Listing),
+		Page:    time.Duration(cfg.Timeouts.Page),
+		Image:   time.Duration(cfg.Timeouts.Image),
+	}
+
+	identities, err := cfg.Clients.identities()
+
+	if err != nil {
+		return nil, err
+	}
+
+	app := &App{
+		DB:              db,
+		Clients:         newClientPool(cfg.PageWorkers*cfg.ChapterWorkers, timeouts, identities...),
+		PageWorkers:     cfg.PageWorkers,
+		ChapterWorkers:  cfg.ChapterWorkers,
+		MaxJobAttempts:  cfg.MaxJobAttempts,
+		LeaseTTL:        time.Duration(cfg.LeaseTTL),
+		InstanceID:      newInstanceID(),
+		Listen:          cfg.Listen,
+		MetricsListen:   cfg.MetricsListen,
+		GCGrace:         time.Duration(cfg.GCGrace),
+		GCDryRun:        cfg.GCDryRun,
+		ShutdownTimeout: time.Duration(cfg.ShutdownTimeout),
+	}
+
+	app.Clients.Limiter = &HostLimiter{RPS: cfg.RateLimit.RPS, Burst: cfg.RateLimit.Burst, Jitter: time.Duration(cfg.RateLimit.Jitter)}
+	app.Clients.Retry = RetryPolicy{MaxAttempts: cfg.Retry.MaxAttempts, BaseDelay: time.Duration(cfg.Retry.BaseDelay), MaxDelay: time.Duration(cfg.Retry.MaxDelay)}
+	app.Clients.MaxFailures = cfg.Clients.MaxFailures
+	app.Clients.BenchTime = time.Duration(cfg.Clients.BenchTime)
+
+	var cache *HTTPCache
+
+	if cfg.Cache.Dir != "" {
+		slog.Info("caching listing pages", "dir", cfg.Cache.Dir, "offline", cfg.Cache.Offline)
+		cache = &HTTPCache{Dir: cfg.Cache.Dir, Offline: cfg.Cache.Offline}
+	}
+
+	if cfg.Site != "" {
+		slog.Info("using site definition", "path", cfg.Site)
+
+		site, err := loadSiteDefinition(cfg.Site)
+
+		if err != nil {
+			return nil, err
+		}
+
+		app.Source = &SiteSource{Site: site, Clients: app.Clients, Cache: cache}
+	} else {
+		slog.Info("using source", "source", cfg.Source)
+
+		app.Source, err = newSource(cfg.Source, app.Clients, cache)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	app.Popularity, err = newPopularityProvider(cfg.Popularity, app, cfg.PopularityFile, cfg.PopularFeedURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("using storage", "kind", cfg.Storage.Kind)
+
+	app.Store, err = newBlobStore(cfg.Storage, cfg.ImageServer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
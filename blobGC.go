@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// blobGCLock is the postgres advisory lock gc-blobs holds exclusively for
+// its whole sweep and every lease holds shared: an unreferenced blob can be
+// reused by a chapter being saved, which must not happen while it is swept.
+const blobGCLock = 0x676f6d67
+
+// lockBlobGC takes blobGCLock with the advisory lock function fn, such as
+// pg_advisory_lock_shared, on a connection of its own since advisory locks
+// belong to the session. The lock is held until unlockBlobGC.
+func lockBlobGC(ctx context.Context, db *gorm.DB, fn string) (*sql.Conn, error) {
+	conn, err := db.DB().Conn(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var locked interface{}
+
+	if err := conn.QueryRowContext(ctx, "SELECT "+fn+"($1)", blobGCLock).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the try variants report whether they got the lock, the others block
+	if ok, isBool := locked.(bool); isBool && !ok {
+		conn.Close()
+		return nil, nil
+	}
+
+	return conn, nil
+}
+
+// unlockBlobGC releases blobGCLock with fn and returns conn to the pool.
+func unlockBlobGC(conn *sql.Conn, fn string) {
+	if _, err := conn.ExecContext(context.Background(), "SELECT "+fn+"($1)", blobGCLock); err != nil {
+		slog.Error("unable to release the gc-blobs lock", "err", err)
+	}
+	conn.Close()
+}
+
+// gcBlobs deletes the blobs that no db_page or db_category row references:
+// the images of chapters and categories that failed before being saved.
+// Blobs written within grace are kept, the chapter that wrote them may still
+// be saving. It holds blobGCLock for the whole sweep and refuses to run
+// while any category is leased, so no chapter reuses a blob being swept.
+// With dryRun the blobs are only logged.
+func (app *App) gcBlobs(ctx context.Context, grace time.Duration, dryRun bool) error {
+	conn, err := lockBlobGC(ctx, app.DB, "pg_try_advisory_lock")
+
+	if err != nil {
+		return err
+	}
+
+	if conn == nil {
+		return fmt.Errorf("categories are being crawled, run gc-blobs while no crawl is running")
+	}
+
+	defer unlockBlobGC(conn, "pg_advisory_unlock")
+
+	// leases of instances that crashed or predate blobGCLock
+	var leased int
+
+	if err := app.DB.Model(&DbCategoryProcessing{}).Where("expires_at > now()").Count(&leased).Error; err != nil {
+		return err
+	}
+
+	if leased > 0 {
+		return fmt.Errorf("%v categories are being crawled, run gc-blobs while no crawl is running", leased)
+	}
+
+	urls, err := app.referencedBlobURLs()
+
+	if err != nil {
+		return err
+	}
+
+	deleted, err := sweepBlobs(ctx, app.Store, blobKeys(urls), time.Now().Add(-grace), dryRun)
+
+	slog.Info("deleted unreferenced blobs", "deleted", deleted, "referenced", len(urls), "dryRun", dryRun)
+
+	return err
+}
+
+// referencedBlobURLs returns the urls of every stored page and category image.
+func (app *App) referencedBlobURLs() ([]string, error) {
+	var pages, categories []string
+
+	if err := app.DB.Model(&DbPage{}).Pluck("hosted_manga_src", &pages).Error; err != nil {
+		return nil, err
+	}
+
+	if err := app.DB.Model(&DbCategory{}).Pluck("hosted_category_image", &categories).Error; err != nil {
+		return nil, err
+	}
+
+	return append(pages, categories...), nil
+}
+
+// blobKeys returns every key the urls may point to: each trailing run of
+// the segments of their paths. Keys are compared rather than urls, which
+// change with the image server or the public url of the bucket.
+func blobKeys(urls []string) stringSet {
+	keys := newStringSet()
+
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Path == "" {
+			continue
+		}
+
+		segments := strings.Split(strings.Trim(path.Clean(u.Path), "/"), "/")
+
+		for i := range segments {
+			keys.Add(strings.Join(segments[i:], "/"))
+		}
+	}
+
+	return keys
+}
+
+// sweepBlobs deletes the blobs of store written before cutoff whose key is
+// not in referenced, and returns how many it deleted, or would delete with
+// dryRun. When keys are referenced but none of them is in store, the
+// references cannot be matched to the store and nothing is deleted.
+func sweepBlobs(ctx context.Context, store BlobStore, referenced stringSet, cutoff time.Time, dryRun bool) (int, error) {
+	var orphans []string
+	found := 0
+
+	err := store.List(func(key string, modified time.Time) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if referenced.Has(key) {
+			found++
+		} else if !modified.After(cutoff) {
+			orphans = append(orphans, key)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(referenced) > 0 && found == 0 {
+		return 0, errors.New("no referenced image is in the store, check the image server and storage settings")
+	}
+
+	deleted := 0
+
+	for _, key := range orphans {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		if dryRun {
+			slog.Info("would delete unreferenced blob", "key", key)
+		} else if err := store.Delete(key); err != nil {
+			return deleted, err
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
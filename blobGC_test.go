@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSharedBlobSurvivesFailedChapter(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &LocalStore{Dir: dir, BaseURL: "http://images.example.com/images"}
+
+	// chapter A writes the shared credits page first, chapter B finds it stored
+	creditsA, _ := putContent(store, []byte("credits"), "image/jpeg")
+	pageA, _ := putContent(store, []byte("page of A"), "image/jpeg")
+	creditsB, _ := putContent(store, []byte("credits"), "image/jpeg")
+	pageB, _ := putContent(store, []byte("page of B"), "image/jpeg")
+
+	if creditsA.Key != creditsB.Key || creditsA.URL != creditsB.URL {
+		t.Fatal(creditsA, creditsB)
+	}
+
+	// B is saved, A fails and leaves its blobs alone
+	referenced := blobKeys([]string{creditsB.URL, pageB.URL})
+
+	if exists, _ := store.Exists(creditsB.Key); !exists {
+		t.Fatal("a failed chapter must not delete a blob another chapter saved")
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, blob := range []Blob{creditsA, pageA, pageB} {
+		os.Chtimes(filepath.Join(dir, filepath.FromSlash(blob.Key)), old, old)
+	}
+
+	fresh, _ := putContent(store, []byte("page being saved"), "image/jpeg")
+
+	deleted, err := sweepBlobs(context.Background(), store, referenced, time.Now().Add(-time.Hour), true)
+
+	if err != nil || deleted != 1 {
+		t.Error(deleted, err)
+	}
+
+	if exists, _ := store.Exists(pageA.Key); !exists {
+		t.Error("a dry run must not delete anything")
+	}
+
+	deleted, err = sweepBlobs(context.Background(), store, referenced, time.Now().Add(-time.Hour), false)
+
+	if err != nil || deleted != 1 {
+		t.Error(deleted, err)
+	}
+
+	for blob, expected := range map[Blob]bool{creditsB: true, pageB: true, fresh: true, pageA: false} {
+		if exists, _ := store.Exists(blob.Key); exists != expected {
+			t.Error(blob.Key, exists)
+		}
+	}
+}
+
+func TestBlobKeysSurviveImageServerMoves(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &LocalStore{Dir: dir, BaseURL: "https://cdn.example.com/images"}
+
+	page, _ := putContent(store, []byte("page"), "image/jpeg")
+
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(dir, filepath.FromSlash(page.Key)), old, old)
+
+	// written by the baseline with a trailing slash on IMAGE_SERVER, before the move
+	referenced := blobKeys([]string{"http://images.example.com//images/" + page.Key})
+
+	deleted, err := sweepBlobs(context.Background(), store, referenced, time.Now(), false)
+
+	if err != nil || deleted != 0 {
+		t.Error(deleted, err)
+	}
+
+	deleted, err = sweepBlobs(context.Background(), store, blobKeys([]string{"http://images.example.com/images/ff/unknown.jpg"}), time.Now(), false)
+
+	if err == nil || deleted != 0 {
+		t.Error("expected a refusal when no reference matches the store", deleted, err)
+	}
+
+	if exists, _ := store.Exists(page.Key); !exists {
+		t.Error("referenced blob was deleted")
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BlobStore stores the scraped images under a key such as "ab/abcdef.jpg"
+// and returns the public url the frontend serves them from. List calls fn
+// for every stored key with the time it was last written.
+type BlobStore interface {
+	Put(key string, data []byte, contentType string) (string, error)
+	Exists(key string) (bool, error)
+	Delete(key string) error
+	URL(key string) string
+	List(fn func(key string, modified time.Time) error) error
+}
+
+// contentKey is the content addressed key for data: the SHA-256 of the
+// bytes, bucketed by its first two hex characters.
+func contentKey(data []byte, ext string) string {
+	sum := sha256Hex(data)
+	return sum[:2] + "/" + sum + ext
+}
+
+// Blob is an object put in the store and the url it is served from.
+type Blob struct {
+	Key string
+	URL string
+}
+
+// putContent stores data under its content key unless an identical blob is
+// already stored, which is then shared with whoever wrote it first.
+func putContent(store BlobStore, data []byte, contentType string) (Blob, error) {
+	key := contentKey(data, ".jpg")
+
+	exists, err := store.Exists(key)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	if exists {
+		return Blob{Key: key, URL: store.URL(key)}, nil
+	}
+
+	url, err := store.Put(key, data, contentType)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	return Blob{Key: key, URL: url}, nil
+}
+
+// LocalStore writes blobs to a directory on the local filesystem.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func (s *LocalStore) Put(key string, data []byte, contentType string) (string, error) {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return "", err
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		// keys are content addressed, a blob another writer stored under key is the same
+		if exists, _ := s.Exists(key); exists {
+			return s.URL(key), nil
+		}
+		return "", err
+	}
+
+	return s.URL(key), nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path, then renames
+// it to path, so a crash never leaves a partial file and concurrent writers
+// of path never share a temporary file. Temporary files end in ".tmp".
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStore) URL(key string) string {
+	return joinURL(s.BaseURL, key)
+}
+
+// List walks Dir, skipping the temporary files of writes in progress.
+func (s *LocalStore) List(fn func(key string, modified time.Time) error) error {
+	err := filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		key, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+
+		return fn(filepath.ToSlash(key), info.ModTime())
+	})
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *LocalStore) Delete(key string) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// S3Store writes blobs to an S3 compatible object store (AWS S3, MinIO, ...)
+// using path-style requests signed with AWS signature version 4.
+type S3Store struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	BaseURL   string
+	Client    *http.Client
+}
+
+var errS3NotFound = errors.New("s3 object not found")
+
+func (s *S3Store) Put(key string, data []byte, contentType string) (string, error) {
+	req, err := http.NewRequest("PUT", s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	if err := s.do(req, data); err != nil {
+		return "", err
+	}
+
+	return s.URL(key), nil
+}
+
+func (s *S3Store) Exists(key string) (bool, error) {
+	req, err := http.NewRequest("HEAD", s.objectURL(key), nil)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.do(req, nil)
+	if err == errS3NotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *S3Store) URL(key string) string {
+	baseURL := s.BaseURL
+	if baseURL == "" {
+		baseURL = joinURL(s.Endpoint, s.Bucket)
+	}
+
+	return joinURL(baseURL, key)
+}
+
+func (s *S3Store) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+
+	return s.do(req, nil)
+}
+
+// List walks the bucket with ListObjectsV2, a page of keys at a time.
+func (s *S3Store) List(fn func(key string, modified time.Time) error) error {
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := http.NewRequest("GET", joinURL(s.Endpoint, s3URIEncode(s.Bucket))+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+
+		body, err := s.send(req, nil)
+		if err != nil {
+			return err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return fmt.Errorf("s3 listing %v: %v", s.Bucket, err)
+		}
+
+		for _, object := range page.Contents {
+			if err := fn(object.Key, object.LastModified); err != nil {
+				return err
+			}
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3Store) objectURL(key string) string {
+	return joinURL(s.Endpoint, s3URIEncode(s.Bucket+"/"+key))
+}
+
+func (s *S3Store) do(req *http.Request, payload []byte) error {
+	_, err := s.send(req, payload)
+	return err
+}
+
+// send signs and sends req, returning the response body.
+func (s *S3Store) send(req *http.Request, payload []byte) ([]byte, error) {
+	s.sign(req, payload, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		if req.Method == "DELETE" {
+			return nil, nil
+		}
+		return nil, errS3NotFound
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("s3 %v %v: %v %s", req.Method, req.URL.Path, res.Status, body)
+	}
+
+	return body, err
+}
+
+func (s *S3Store) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v", s.AccessKey, scope, signedHeaders, signature))
+}
+
+func newBlobStore(cfg StorageConfig, imageServer string) (BlobStore, error) {
+	switch cfg.Kind {
+	case "local":
+		return &LocalStore{Dir: cfg.Dir, BaseURL: joinURL(imageServer, "images")}, nil
+	case "s3":
+		if cfg.S3.Endpoint == "" || cfg.S3.Bucket == "" {
+			return nil, fmt.Errorf("s3 storage requires an endpoint and a bucket")
+		}
+		s3 := &S3Store{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			BaseURL:   cfg.S3.PublicURL,
+			Client:    &http.Client{Timeout: 2 * time.Minute},
+		}
+		if s3.Region == "" {
+			s3.Region = "us-east-1"
+		}
+		return s3, nil
+	}
+
+	return nil, fmt.Errorf("unknown storage %q, expected 'local' or 's3'", cfg.Kind)
+}
+
+func joinURL(base string, path string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// s3URIEncode escapes an object path the way signature version 4 expects.
+func s3URIEncode(s string) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~', b == '/':
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store := &LocalStore{Dir: dir, BaseURL: "http://images.example.com/images/"}
+
+	result, err := store.Put("42/abc.jpg", []byte("jpeg"), "image/jpeg")
+
+	if err != nil || result != "http://images.example.com/images/42/abc.jpg" {
+		t.Error(result, err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "42", "abc.jpg"))
+
+	if err != nil || string(b) != "jpeg" {
+		t.Error(string(b), err)
+	}
+
+	if exists, err := store.Exists("42/abc.jpg"); !exists || err != nil {
+		t.Error(exists, err)
+	}
+
+	if err := store.Delete("42/abc.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	if err := store.Delete("42/abc.jpg"); err != nil {
+		t.Error("deleting a missing blob should not fail", err)
+	}
+}
+
+func TestLocalStoreConcurrentPut(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store := &LocalStore{Dir: dir, BaseURL: "http://images.example.com/images/"}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Put("ab/shared.jpg", []byte("credits"), "image/jpeg")
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "ab", "shared.jpg"))
+
+	if err != nil || string(b) != "credits" {
+		t.Error(string(b), err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "ab"))
+
+	if len(files) != 1 {
+		t.Error("temporary files were left behind", len(files))
+	}
+}
+
+func TestS3Store(t *testing.T) {
+
+	objects := make(map[string]string)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case "HEAD":
+			if _, ok := objects[r.URL.Path]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = string(b)
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	defer server.Close()
+
+	store := &S3Store{Endpoint: server.URL, Region: "us-east-1", Bucket: "images", AccessKey: "key", SecretKey: "secret"}
+
+	result, err := store.Put("42/abc.jpg", []byte("jpeg"), "image/jpeg")
+
+	if err != nil || result != server.URL+"/images/42/abc.jpg" {
+		t.Error(result, err)
+	}
+
+	if objects["/images/42/abc.jpg"] != "jpeg" {
+		t.Error(objects)
+	}
+
+	if exists, err := store.Exists("42/abc.jpg"); !exists || err != nil {
+		t.Error(exists, err)
+	}
+
+	if exists, err := store.Exists("42/missing.jpg"); exists || err != nil {
+		t.Error(exists, err)
+	}
+
+	if err := store.Delete("42/abc.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	if len(objects) != 0 {
+		t.Error(objects)
+	}
+
+	store.SecretKey = ""
+	store.AccessKey = "wrong"
+
+	if _, err := store.Put("42/abc.jpg", []byte("jpeg"), "image/jpeg"); err == nil {
+		t.Error("expected error for rejected request")
+	}
+}
+
+func TestS3URIEncode(t *testing.T) {
+
+	result := s3URIEncode("images/42/a b+c.jpg")
+
+	if result != "images/42/a%20b%2Bc.jpg" {
+		t.Error(result)
+	}
+}
+
+func TestPutContent(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store := &LocalStore{Dir: dir, BaseURL: "http://images.example.com/images"}
+
+	first, err := putContent(store, []byte("page"), "image/jpeg")
+
+	if err != nil {
+		t.Error(first, err)
+	}
+
+	key := contentKey([]byte("page"), ".jpg")
+
+	if key != "36/3660315a9af3df255d8f19ab077e4797822b41488a0e2a04bc6af71213c23274.jpg" {
+		t.Error(key)
+	}
+
+	if first.Key != key || first.URL != "http://images.example.com/images/"+key {
+		t.Error(first)
+	}
+
+	second, err := putContent(store, []byte("page"), "image/jpeg")
+
+	if err != nil || second.URL != first.URL {
+		t.Error(second, err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, key[:2]))
+
+	if len(files) != 1 {
+		t.Error(len(files))
+	}
+}
+
+func TestS3StoreList(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/images" || r.URL.Query().Get("list-type") != "2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if r.URL.Query().Get("continuation-token") == "next/page+1" {
+			fmt.Fprint(w, `<ListBucketResult><Contents><Key>cd/cd.jpg</Key><LastModified>2017-08-09T00:00:00.000Z</LastModified></Contents><IsTruncated>false</IsTruncated></ListBucketResult>`)
+			return
+		}
+
+		fmt.Fprint(w, `<ListBucketResult><Contents><Key>ab/ab.jpg</Key><LastModified>2017-08-08T00:00:00.000Z</LastModified></Contents>
+			<IsTruncated>true</IsTruncated><NextContinuationToken>next/page+1</NextContinuationToken></ListBucketResult>`)
+	}))
+
+	defer server.Close()
+
+	store := &S3Store{Endpoint: server.URL, Region: "us-east-1", Bucket: "images", AccessKey: "key", SecretKey: "secret"}
+
+	var keys []string
+
+	err := store.List(func(key string, modified time.Time) error {
+		keys = append(keys, key+" "+modified.Format("2006-01-02"))
+		return nil
+	})
+
+	if err != nil || !reflect.DeepEqual(keys, []string{"ab/ab.jpg 2017-08-08", "cd/cd.jpg 2017-08-09"}) {
+		t.Error(keys, err)
+	}
+}
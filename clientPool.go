@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// FetchTimeouts bound every attempt at a fetch, by what is fetched. A zero
+// timeout leaves the attempt bounded by its context only.
+type FetchTimeouts struct {
+	// Listing is for the category listing and the page of a category.
+	Listing time.Duration
+	// Page is for the pages of a chapter.
+	Page time.Duration
+	// Image is for image downloads.
+	Image time.Duration
+}
+
+// Identity is how a client shows itself to sources: the proxy it goes out
+// through, nil for a direct connection, and the User-Agent it sends, empty
+// for Go's default.
+type Identity struct {
+	Proxy     *url.URL
+	UserAgent string
+}
+
+// name identifies the identity in logs and metrics without the proxy's
+// credentials.
+func (id Identity) name() string {
+	if id.Proxy == nil {
+		return "direct"
+	}
+	return id.Proxy.Scheme + "://" + id.Proxy.Host
+}
+
+// ClientPool hands out http clients, at most size at once. There is a
+// client for every identity, picked in turn; a client whose requests fail
+// MaxFailures times in a row is benched for BenchTime while the others are
+// used. The clients have no timeout of their own, requests are bounded by
+// their context and Timeouts. Fetches through the pool are throttled by
+// Limiter and retried by Retry.
+type ClientPool struct {
+	Timeouts    FetchTimeouts
+	Limiter     *HostLimiter
+	Retry       RetryPolicy
+	MaxFailures int
+	BenchTime   time.Duration
+
+	slots chan struct{}
+
+	mu      sync.Mutex
+	clients []*poolClient
+	next    int
+}
+
+type poolClient struct {
+	Identity
+	client http.Client
+
+	failures     int
+	benchedUntil time.Time
+}
+
+// newClientPool returns a pool of size concurrent clients going out through
+// identities, or directly when there are none. Benching is disabled until
+// MaxFailures is set and fetches are tried once until Retry is set.
+func newClientPool(size int, timeouts FetchTimeouts, identities ...Identity) *ClientPool {
+	if len(identities) == 0 {
+		identities = []Identity{{}}
+	}
+
+	pool := &ClientPool{Timeouts: timeouts, Limiter: &HostLimiter{}, slots: make(chan struct{}, size)}
+
+	for i := 0; i < size; i++ {
+		pool.slots <- struct{}{}
+	}
+
+	for _, id := range identities {
+		proxy := http.ProxyFromEnvironment
+		if id.Proxy != nil {
+			proxy = http.ProxyURL(id.Proxy)
+		}
+
+		c := &poolClient{Identity: id}
+		c.client = http.Client{Transport: &identityTransport{
+			pool:   pool,
+			client: c,
+			base:   &http.Transport{Proxy: proxy, MaxIdleConnsPerHost: size},
+		}}
+
+		pool.clients = append(pool.clients, c)
+	}
+
+	slog.Debug("http client pool ready", "size", size, "identities", len(identities))
+
+	return pool
+}
+
+// Acquire blocks until a client is free and returns the next healthy one.
+// When every client is benched it returns the one back soonest.
+func (p *ClientPool) Acquire() http.Client {
+	start := time.Now()
+	<-p.slots
+	clientWaitSeconds.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var soonest *poolClient
+
+	for i := range p.clients {
+		c := p.clients[(p.next+i)%len(p.clients)]
+
+		if !c.benchedUntil.After(now) {
+			p.next = (p.next + i + 1) % len(p.clients)
+			return c.client
+		}
+
+		if soonest == nil || c.benchedUntil.Before(soonest.benchedUntil) {
+			soonest = c
+		}
+	}
+
+	return soonest.client
+}
+
+func (p *ClientPool) Release(c http.Client) {
+	p.slots <- struct{}{}
+}
+
+// record counts a failed or successful request of c, benching it when it
+// reaches MaxFailures failures in a row.
+func (p *ClientPool) record(c *poolClient, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !failed {
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+
+	if p.MaxFailures > 0 && c.failures >= p.MaxFailures {
+		slog.Warn("benching http client", "identity", c.name(), "failures", c.failures, "for", p.BenchTime)
+
+		clientsBenched.Inc(c.name())
+		c.failures = 0
+		c.benchedUntil = time.Now().Add(p.BenchTime)
+	}
+}
+
+// identityTransport sends requests with the User-Agent of client and
+// reports their outcome to pool.
+type identityTransport struct {
+	pool   *ClientPool
+	client *poolClient
+	base   http.RoundTripper
+}
+
+func (t *identityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.client.UserAgent != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.client.UserAgent)
+	}
+
+	res, err := t.base.RoundTrip(req)
+
+	switch {
+	case err != nil:
+		// a request cancelled by its caller says nothing about the client
+		if !errors.Is(req.Context().Err(), context.Canceled) {
+			t.pool.record(t.client, true)
+		}
+	case isBlocked(res.StatusCode):
+		t.pool.record(t.client, true)
+	default:
+		t.pool.record(t.client, false)
+	}
+
+	return res, err
+}
+
+// isBlocked reports whether a response with statusCode means the source or
+// the proxy refuses this client, rather than that the request failed.
+func isBlocked(statusCode int) bool {
+	return statusCode == http.StatusForbidden ||
+		statusCode == http.StatusProxyAuthRequired ||
+		statusCode == http.StatusTooManyRequests
+}
+
+// identities pairs the configured proxies with the configured user agents,
+// cycling through the shorter list.
+func (c ClientsConfig) identities() ([]Identity, error) {
+	n := len(c.Proxies)
+	if len(c.UserAgents) > n {
+		n = len(c.UserAgents)
+	}
+
+	identities := make([]Identity, n)
+
+	for i := range identities {
+		if len(c.Proxies) > 0 {
+			proxy, err := parseProxy(c.Proxies[i%len(c.Proxies)])
+			if err != nil {
+				return nil, err
+			}
+			identities[i].Proxy = proxy
+		}
+
+		if len(c.UserAgents) > 0 {
+			identities[i].UserAgent = c.UserAgents[i%len(c.UserAgents)]
+		}
+	}
+
+	return identities, nil
+}
+
+// parseProxy parses an http, https or socks5 proxy url. Errors leave out
+// the proxy's password.
+func parseProxy(s string) (*url.URL, error) {
+	proxy, err := url.Parse(s)
+	if err != nil {
+		// the parse error would repeat s, password and all
+		return nil, errors.New("a proxy is not a valid url")
+	}
+
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %v must be an http, https or socks5 url", redactSecrets(s))
+	}
+
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("proxy %v has no host", redactSecrets(s))
+	}
+
+	return proxy, nil
+}
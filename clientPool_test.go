@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientsConfigIdentities(t *testing.T) {
+
+	cfg := ClientsConfig{
+		Proxies:    []string{"http://a:3128", "socks5://b:1080"},
+		UserAgents: []string{"ua1", "ua2", "ua3"},
+	}
+
+	identities, err := cfg.identities()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result []string
+	for _, id := range identities {
+		result = append(result, id.name()+" "+id.UserAgent)
+	}
+
+	if expected := []string{"http://a:3128 ua1", "socks5://b:1080 ua2", "http://a:3128 ua3"}; !reflect.DeepEqual(result, expected) {
+		t.Error(result)
+	}
+
+	if identities, _ := (ClientsConfig{}).identities(); len(identities) != 0 {
+		t.Error(identities)
+	}
+
+	for _, proxy := range []string{"ftp://user:hunter2@a", "http://", "://user:hunter2@a"} {
+		_, err := ClientsConfig{Proxies: []string{proxy}}.identities()
+
+		if err == nil || strings.Contains(err.Error(), "hunter2") {
+			t.Error(proxy, err)
+		}
+	}
+}
+
+func TestClientPoolBenchesFailingClient(t *testing.T) {
+
+	var seen []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.UserAgent())
+		if r.UserAgent() == "bad" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	pool := newClientPool(1, FetchTimeouts{}, Identity{UserAgent: "bad"}, Identity{UserAgent: "good"})
+	pool.MaxFailures = 2
+	pool.BenchTime = time.Hour
+
+	for i := 0; i < 6; i++ {
+		c := pool.Acquire()
+
+		res, err := c.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		pool.Release(c)
+	}
+
+	if expected := []string{"bad", "good", "bad", "good", "good", "good"}; !reflect.DeepEqual(seen, expected) {
+		t.Error(seen)
+	}
+}
+
+func TestClientPoolUsesProxy(t *testing.T) {
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	pool := newClientPool(1, FetchTimeouts{}, Identity{Proxy: proxyURL})
+
+	c := pool.Acquire()
+	defer pool.Release(c)
+
+	res, err := c.Get("http://gomg.invalid/naruto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+
+	if result := string(body); result != "proxied http://gomg.invalid/naruto" {
+		t.Error(result)
+	}
+}
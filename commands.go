@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CrawlOptions are the flags that pick which categories a crawl visits.
+type CrawlOptions struct {
+	RunMode   string
+	TopN      int
+	IsReverse bool
+}
+
+// Command is a gomg subcommand taking exactly Args positional arguments.
+// Only Offline commands can run with -offline, the others fetch more than
+// the cached listing pages. NoDB commands run without a database, App.DB is
+// nil, unless they rank categories by hits.
+type Command struct {
+	Usage   string
+	Help    string
+	Args    int
+	Offline bool
+	NoDB    bool
+	Run     func(ctx context.Context, app *App, opts CrawlOptions, args []string) error
+}
+
+// needsDB reports whether cmd uses the database when run with cfg.
+func (cmd Command) needsDB(cfg *Config) bool {
+	return !cmd.NoDB || (cfg.RunMode == "top" && cfg.Popularity == "hits")
+}
+
+var commands = map[string]Command{
+	"crawl": {
+		Usage: "crawl",
+		Help:  "crawl every category forever (default)",
+		Run: func(ctx context.Context, app *App, opts CrawlOptions, args []string) error {
+			return app.crawl(ctx, opts)
+		},
+	},
+	"once": {
+		Usage: "once",
+		Help:  "crawl every category once, then exit",
+		Run: func(ctx context.Context, app *App, opts CrawlOptions, args []string) error {
+			_, err := app.crawlOnce(ctx, opts)
+			return err
+		},
+	},
+	"crawl-category": {
+		Usage: "crawl-category <name>",
+		Help:  "crawl the new chapters of a single category",
+		Args:  1,
+		Run: func(ctx context.Context, app *App, opts CrawlOptions, args []string) error {
+			return app.crawlCategory(ctx, args[0])
+		},
+	},
+	"crawl-chapter": {
+		Usage: "crawl-chapter <url>",
+		Help:  "crawl a single chapter that is not saved yet",
+		Args:  1,
+		Run: func(ctx context.Context, app *App, opts CrawlOptions, args []string) error {
+			return app.crawlChapter(ctx, args[0])
+		},
+	},
+	"retry-failed": {
+		Usage: "retry-failed",
+		Help:  "reprocess the chapters recorded in db_failed_job once, then exit",
+		Run: func(ctx context.Context, app *App, opts CrawlOptions, args []string) error {
+			return app.retryFailedJobs(ctx)
+		},
+	},
+	"list-categories": {
+		Usage:   "list-categories",
+		Help:    "print the categories the source lists",
+		Offline: true,
+		NoDB:    true,
+		Run: func(ctx context.Context, app *App, opts CrawlOptions, args []string) error {
+			return app.listCategories(ctx, opts)
+		},
+	},
+	"list-chapters": {
+		Usage:   "list-chapters <name>",
+		Help:    "print the chapters the source lists for a category",
+		Args:    1,
+		Offline: true,
+		NoDB:    true,
+		Run: func(ctx context.Context, app *App, opts CrawlOptions, args []string) error {
+			return app.listChapters(ctx, args[0])
+		},
+	},
+	"serve": {
+		Usage:   "serve",
+		Help:    "serve the library as a json api on -listen",
+		Offline: true,
+		Run:     func(ctx context.Context, app *App, opts CrawlOptions, args []string) error { return app.serve(ctx) },
+	},
+	"gc-blobs": {
+		Usage:   "gc-blobs",
+		Help:    "delete stored images that no page or category references",
+		Offline: true,
+		Run: func(ctx context.Context, app *App, opts CrawlOptions, args []string) error {
+			return app.gcBlobs(ctx, app.GCGrace, app.GCDryRun)
+		},
+	},
+	"status": {
+		Usage:   "status",
+		Help:    "print library counts, held leases and failed jobs",
+		Offline: true,
+		Run:     func(ctx context.Context, app *App, opts CrawlOptions, args []string) error { return app.status() },
+	},
+}
+
+// parseCommand splits the subcommand off args; without one gomg crawls.
+func parseCommand(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "crawl", args
+}
+
+// offlineCommands are the sorted names of the commands that can run -offline.
+func offlineCommands() (names []string) {
+	for name, cmd := range commands {
+		if cmd.Offline {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "usage: gomg [command] [flags] [args]\n\ncommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-24v %v\n", commands[name].Usage, commands[name].Help)
+	}
+	fmt.Fprintf(out, "  %-24v %v\n", "migrate up|down|status", "manage the database schema")
+
+	fmt.Fprintf(out, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+// crawl crawls every category over and over until ctx is done.
+func (app *App) crawl(ctx context.Context, opts CrawlOptions) error {
+	for ctx.Err() == nil {
+		processed, err := app.crawlOnce(ctx, opts)
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err != nil {
+			slog.Error("crawl failed, retrying in 5m", "err", err)
+			sleep(ctx, 5*time.Minute)
+		} else if processed == 0 {
+			slog.Info("no category was processed, crawling again in 1m")
+			sleep(ctx, time.Minute)
+		}
+	}
+
+	return nil
+}
+
+// crawlOnce processes every category the run mode selects once and returns
+// how many were processed by this instance. Once ctx is done no new category
+// is picked.
+func (app *App) crawlOnce(ctx context.Context, opts CrawlOptions) (processed int, err error) {
+	slog.Info("starting crawl")
+
+	categories, err := app.selectCategories(ctx, opts)
+
+	if err != nil {
+		return 0, err
+	}
+
+	slog.Info("categories to process", "count", len(categories))
+
+	for _, category := range categories {
+		if ctx.Err() != nil {
+			slog.Info("shutting down, no new categories are picked")
+			return processed, ctx.Err()
+		}
+
+		if app.processCategory(ctx, category) {
+			processed++
+		}
+	}
+
+	return processed, nil
+}
+
+func (app *App) selectCategories(ctx context.Context, opts CrawlOptions) ([]Category, error) {
+	categories, err := app.Source.Categories(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.RunMode == "top" {
+		categories, err = app.filterToTop(ctx, categories, opts.TopN)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.IsReverse {
+		slog.Info("running in reverse")
+		categories = reverse(categories)
+	}
+
+	return categories, nil
+}
+
+// processCategory leases cat and processes its new chapters. It returns false
+// when another instance holds the lease. Once ctx is done no new chapter is
+// started, and the lease is released after the running ones are drained.
+// Losing the lease cancels and rolls back the running chapters.
+func (app *App) processCategory(ctx context.Context, cat Category) bool {
+	// take the lease on the category, if another instance holds it, go to next one.
+	queryCategoryName := ReplaceSpecial(cat.Name)
+	lease, ok, err := app.acquireLease(ctx, queryCategoryName)
+
+	if err != nil {
+		slog.Error("unable to lease category", "category", queryCategoryName, "err", err)
+		return false
+	}
+
+	if !ok {
+		slog.Info("category is being processed by another instance", "category", queryCategoryName)
+		sleep(ctx, 3*time.Second)
+		return false
+	}
+
+	defer func() {
+		if err := lease.Release(); err != nil {
+			slog.Error("unable to release lease", "category", queryCategoryName, "err", err)
+		}
+	}()
+
+	slog.Info("processing category", "category", cat.Name)
+
+	jobs := app.getNewJobs(ctx, cat)
+
+	categoriesScanned.Inc()
+	chaptersDiscovered.Add(float64(len(jobs)))
+
+	work, cancel := app.workContext(ctx)
+	defer cancel()
+
+	work, cancelLease := lease.Context(work)
+	defer cancelLease()
+
+	parallel(len(jobs), app.ChapterWorkers, func(i int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := work.Err(); err != nil {
+			return err
+		}
+		app.runJob(work, jobs[i])
+		return nil
+	})
+
+	slog.Info("completed category", "category", cat.Name, "chapters", len(jobs))
+
+	return true
+}
+
+func (app *App) findCategory(ctx context.Context, name string) (Category, error) {
+	categories, err := app.Source.Categories(ctx)
+
+	if err != nil {
+		return Category{}, err
+	}
+
+	queryCategoryName := ReplaceSpecial(name)
+
+	for _, cat := range categories {
+		if strings.EqualFold(cat.Name, queryCategoryName) {
+			return cat, nil
+		}
+	}
+
+	return Category{}, fmt.Errorf("category %q not found on %v", name, app.sourceName())
+}
+
+func (app *App) crawlCategory(ctx context.Context, name string) error {
+	cat, err := app.findCategory(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	if !app.processCategory(ctx, cat) {
+		return fmt.Errorf("category %v is being processed by another instance", cat.Name)
+	}
+
+	return nil
+}
+
+// crawlChapter processes the chapter at link. Its category is the one whose
+// link is a prefix of the chapter link.
+func (app *App) crawlChapter(ctx context.Context, link string) error {
+	chapterLink, err := url.Parse(link)
+
+	if err != nil {
+		return err
+	}
+
+	categories, err := app.Source.Categories(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	var category *Category
+
+	for i, cat := range categories {
+		if cat.Link.Host == chapterLink.Host && strings.HasPrefix(chapterLink.Path, strings.TrimSuffix(cat.Link.Path, "/")+"/") {
+			if category == nil || len(cat.Link.Path) > len(category.Link.Path) {
+				category = &categories[i]
+			}
+		}
+	}
+
+	if category == nil {
+		return fmt.Errorf("no category on %v contains %v", app.sourceName(), link)
+	}
+
+	existing, err := app.existingChaptersInDb(*category)
+
+	if err != nil {
+		return err
+	}
+
+	if existing.Has(chapterLink.String()) {
+		return fmt.Errorf("chapter %v is already saved", link)
+	}
+
+	chapters, err := app.Source.Chapters(ctx, *category)
+
+	if err != nil {
+		return err
+	}
+
+	for _, chapter := range chapters {
+		if chapter.Link.String() == chapterLink.String() {
+			job := ChapterJobContext{Category: *category, Chapter: chapter}
+
+			lease, ok, err := app.acquireLease(ctx, ReplaceSpecial(category.Name))
+
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				return fmt.Errorf("category %v is being processed by another instance", category.Name)
+			}
+
+			defer func() {
+				if err := lease.Release(); err != nil {
+					slog.Error("unable to release lease", "category", lease.CategoryName, "err", err)
+				}
+			}()
+
+			work, cancel := app.workContext(ctx)
+			defer cancel()
+
+			work, cancelLease := lease.Context(work)
+			defer cancelLease()
+
+			if err := app.worker(work, job); err != nil {
+				if work.Err() == nil {
+					app.recordFailedJob(job, err)
+				}
+				return err
+			}
+
+			app.clearFailedJob(job)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("chapter %v not listed in category %v", link, category.Name)
+}
+
+func (app *App) listCategories(ctx context.Context, opts CrawlOptions) error {
+	categories, err := app.selectCategories(ctx, opts)
+
+	if err != nil {
+		return err
+	}
+
+	for _, cat := range categories {
+		fmt.Fprintf(os.Stdout, "%v\t%v\n", cat.Name, cat.Link)
+	}
+
+	return nil
+}
+
+func (app *App) listChapters(ctx context.Context, name string) error {
+	cat, err := app.findCategory(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	chapters, err := app.Source.Chapters(ctx, cat)
+
+	if err != nil {
+		return err
+	}
+
+	for _, chapter := range chapters {
+		fmt.Fprintf(os.Stdout, "%v\t%v\n", chapter.Name, chapter.Link)
+	}
+
+	return nil
+}
+
+func (app *App) status() error {
+	counts := []struct {
+		Name  string
+		Model interface{}
+		Where string
+	}{
+		{"categories", &DbCategory{}, ""},
+		{"chapters", &DbChapter{}, ""},
+		{"pages", &DbPage{}, ""},
+		{"failed jobs", &DbFailedJob{}, "dead = false"},
+		{"dead jobs", &DbFailedJob{}, "dead = true"},
+	}
+
+	for _, c := range counts {
+		var count int
+
+		query := app.DB.Model(c.Model)
+		if c.Where != "" {
+			query = query.Where(c.Where)
+		}
+
+		if err := query.Count(&count).Error; err != nil {
+			return err
+		}
+
+		fmt.Printf("%-12v %v\n", c.Name, count)
+	}
+
+	var leases []DbCategoryProcessing
+
+	if err := app.DB.Order("category_name").Find(&leases).Error; err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%v categories in processing\n", len(leases))
+
+	for _, l := range leases {
+		state := "held"
+		if l.ExpiresAt.Before(time.Now()) {
+			state = "expired"
+		}
+		fmt.Printf("  %v\t%v\t%v until %v\n", l.CategoryName, l.Owner, state, l.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func (app *App) sourceName() string {
+	if s, ok := app.Source.(*SiteSource); ok {
+		return s.Site.Name
+	}
+	return "source"
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+
+	command, args := parseCommand([]string{"-runMode", "top30"})
+
+	if command != "crawl" || !reflect.DeepEqual(args, []string{"-runMode", "top30"}) {
+		t.Error(command, args)
+	}
+
+	command, args = parseCommand([]string{"crawl-category", "-pageWorkers", "2", "Naruto"})
+
+	if command != "crawl-category" || !reflect.DeepEqual(args, []string{"-pageWorkers", "2", "Naruto"}) {
+		t.Error(command, args)
+	}
+
+	command, args = parseCommand(nil)
+
+	if command != "crawl" || len(args) != 0 {
+		t.Error(command, args)
+	}
+}
+
+func TestOfflineCommands(t *testing.T) {
+
+	names := offlineCommands()
+
+	if !reflect.DeepEqual(names, []string{"gc-blobs", "list-categories", "list-chapters", "serve", "status"}) {
+		t.Error(names)
+	}
+
+	if cmd, ok := commands["retry-failed"]; !ok || cmd.Offline || cmd.Args != 0 {
+		t.Error(cmd)
+	}
+}
+
+func TestListingCommandsNeedNoDB(t *testing.T) {
+
+	cfg := defaultConfig()
+
+	if commands["list-categories"].needsDB(&cfg) || commands["list-chapters"].needsDB(&cfg) || !commands["crawl"].needsDB(&cfg) {
+		t.Error("only crawling commands should need the database")
+	}
+
+	cfg.RunMode = "top"
+
+	if !commands["list-categories"].needsDB(&cfg) {
+		t.Error("ranking categories by hits needs the database")
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is everything gomg needs to run. It is built from defaultConfig,
+// then the json file given by -config, then environment variables, then the
+// remaining command line flags, each layer overriding the previous one.
+type Config struct {
+	Postgres        PostgresConfig  `json:"postgres"`
+	ImageServer     string          `json:"imageServer"`
+	Popularity      string          `json:"popularity"`
+	PopularityFile  string          `json:"popularityFile"`
+	PopularFeedURL  string          `json:"popularFeedUrl"`
+	Listen          string          `json:"listen"`
+	MetricsListen   string          `json:"metricsListen"`
+	RunMode         string          `json:"runMode"`
+	TopN            int             `json:"topN"`
+	IsReverse       bool            `json:"isReverse"`
+	Source          string          `json:"source"`
+	Site            string          `json:"site"`
+	Storage         StorageConfig   `json:"storage"`
+	PageWorkers     int             `json:"pageWorkers"`
+	ChapterWorkers  int             `json:"chapterWorkers"`
+	Timeouts        TimeoutConfig   `json:"timeouts"`
+	Clients         ClientsConfig   `json:"clients"`
+	Cache           CacheConfig     `json:"cache"`
+	RateLimit       RateLimitConfig `json:"rateLimit"`
+	Retry           RetryConfig     `json:"retry"`
+	MaxJobAttempts  int             `json:"maxJobAttempts"`
+	LeaseTTL        Duration        `json:"leaseTTL"`
+	GCGrace         Duration        `json:"gcGrace"`
+	GCDryRun        bool            `json:"gcDryRun"`
+	ShutdownTimeout Duration        `json:"shutdownTimeout"`
+	Log             LogConfig       `json:"log"`
+}
+
+type PostgresConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	DB       string `json:"db"`
+	SSLMode  string `json:"sslMode"`
+}
+
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+type StorageConfig struct {
+	Kind string   `json:"kind"`
+	Dir  string   `json:"dir"`
+	S3   S3Config `json:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	PublicURL string `json:"publicUrl"`
+}
+
+// TimeoutConfig bounds every attempt at a fetch, by what is fetched.
+type TimeoutConfig struct {
+	Listing Duration `json:"listing"`
+	Page    Duration `json:"page"`
+	Image   Duration `json:"image"`
+}
+
+// ClientsConfig is the identities sources are fetched with. Proxies are
+// http, https or socks5 urls; without any, requests go out directly.
+type ClientsConfig struct {
+	Proxies     []string `json:"proxies"`
+	UserAgents  []string `json:"userAgents"`
+	MaxFailures int      `json:"maxFailures"`
+	BenchTime   Duration `json:"benchTime"`
+}
+
+// CacheConfig is where listing pages are cached, empty for no cache, and
+// whether they are only replayed from it.
+type CacheConfig struct {
+	Dir     string `json:"dir"`
+	Offline bool   `json:"offline"`
+}
+
+type RateLimitConfig struct {
+	RPS    float64  `json:"rps"`
+	Burst  int      `json:"burst"`
+	Jitter Duration `json:"jitter"`
+}
+
+type RetryConfig struct {
+	MaxAttempts int      `json:"maxAttempts"`
+	BaseDelay   Duration `json:"baseDelay"`
+	MaxDelay    Duration `json:"maxDelay"`
+}
+
+// listFlag is a flag that can be repeated. The first use replaces the list
+// from the config file, later ones add to it.
+type listFlag struct {
+	list *[]string
+	set  bool
+}
+
+func (f *listFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return strings.Join(*f.list, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	if !f.set {
+		*f.list = nil
+		f.set = true
+	}
+	*f.list = append(*f.list, value)
+	return nil
+}
+
+// Duration is a time.Duration written as "250ms" or "5m" in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5m\": %v", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func defaultConfig() Config {
+	return Config{
+		Postgres:        PostgresConfig{Host: "localhost", Port: 5432, User: "postgres", DB: "postgres"},
+		Listen:          ":3000",
+		RunMode:         "full",
+		TopN:            30,
+		Popularity:      "hits",
+		Source:          "mangareader",
+		Storage:         StorageConfig{Kind: "local", Dir: "images", S3: S3Config{Region: "us-east-1"}},
+		PageWorkers:     4,
+		ChapterWorkers:  2,
+		Timeouts:        TimeoutConfig{Listing: Duration(time.Minute), Page: Duration(30 * time.Second), Image: Duration(time.Minute)},
+		Clients:         ClientsConfig{MaxFailures: 3, BenchTime: Duration(5 * time.Minute)},
+		RateLimit:       RateLimitConfig{RPS: 2, Burst: 4, Jitter: Duration(250 * time.Millisecond)},
+		Retry:           RetryConfig{MaxAttempts: 4, BaseDelay: Duration(time.Second), MaxDelay: Duration(time.Minute)},
+		MaxJobAttempts:  5,
+		LeaseTTL:        Duration(5 * time.Minute),
+		GCGrace:         Duration(24 * time.Hour),
+		ShutdownTimeout: Duration(2 * time.Minute),
+		Log:             LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+// loadConfig builds the Config for args, the command line without the
+// subcommand, binding the config flags onto fs and parsing it, then checks
+// it with validate. Flags that are not part of the config can be defined on
+// fs beforehand.
+func loadConfig(fs *flag.FlagSet, args []string, getenv func(string) string, validate func(*Config) error) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path := configPath(args); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing config %v: %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	cfg.bindFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// top30 predates -topN
+	if cfg.RunMode == "top30" {
+		cfg.RunMode = "top"
+		cfg.TopN = 30
+	}
+
+	if err := validate(&cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// configPath finds -config ahead of the real flag parsing, since the file
+// has to be loaded before flags can override it.
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return ""
+}
+
+// applyEnv reads the environment variables the docker setup has always used.
+func (c *Config) applyEnv(getenv func(string) string) error {
+	vars := map[string]*string{
+		"POSTGRES_PORT_5432_TCP_ADDR": &c.Postgres.Host,
+		"POSTGRES_USER":               &c.Postgres.User,
+		"POSTGRES_PASSWORD":           &c.Postgres.Password,
+		"POSTGRES_DB":                 &c.Postgres.DB,
+		"POSTGRES_SSLMODE":            &c.Postgres.SSLMode,
+		"IMAGE_SERVER":                &c.ImageServer,
+		"POPULAR_FEED_URL":            &c.PopularFeedURL,
+		"S3_ACCESS_KEY":               &c.Storage.S3.AccessKey,
+		"S3_SECRET_KEY":               &c.Storage.S3.SecretKey,
+		"LOG_LEVEL":                   &c.Log.Level,
+		"LOG_FORMAT":                  &c.Log.Format,
+	}
+
+	for name, field := range vars {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	if value := getenv("POSTGRES_PORT_5432_TCP_PORT"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("POSTGRES_PORT_5432_TCP_PORT must be a number, got %q", value)
+		}
+		c.Postgres.Port = port
+	}
+
+	return nil
+}
+
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.String("config", "", "path to a json config file, overridden by environment variables and flags")
+	fs.StringVar(&c.RunMode, "runMode", c.RunMode, "run mode: either 'full' or 'top', the -topN most popular categories ('top30' is -runMode=top -topN=30)")
+	fs.IntVar(&c.TopN, "topN", c.TopN, "number of categories crawled with -runMode=top")
+	fs.StringVar(&c.Popularity, "popularity", c.Popularity, "how -runMode=top ranks categories: 'hits' (chapter views), 'file' (-popularityFile) or 'feed' (POPULAR_FEED_URL)")
+	fs.StringVar(&c.PopularityFile, "popularityFile", c.PopularityFile, "json list of category names, most popular first, used by -popularity=file")
+	fs.BoolVar(&c.IsReverse, "isReverse", c.IsReverse, "run reverse?")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address the api is served on by the serve command")
+	fs.StringVar(&c.MetricsListen, "metricsListen", c.MetricsListen, "address other commands serve /metrics on, e.g. :9090, empty to disable")
+	fs.StringVar(&c.Source, "source", c.Source, fmt.Sprintf("built-in site to scrape, one of %v", sourceNames()))
+	fs.StringVar(&c.Site, "site", c.Site, "path to a json site definition, overrides -source")
+	fs.StringVar(&c.Storage.Kind, "storage", c.Storage.Kind, "image storage: either 'local' or 's3'")
+	fs.StringVar(&c.Storage.Dir, "storageDir", c.Storage.Dir, "directory images are written to with -storage=local, served from IMAGE_SERVER/images")
+	fs.StringVar(&c.Storage.S3.Endpoint, "s3Endpoint", c.Storage.S3.Endpoint, "s3 compatible endpoint, e.g. http://localhost:9000")
+	fs.StringVar(&c.Storage.S3.Region, "s3Region", c.Storage.S3.Region, "s3 region")
+	fs.StringVar(&c.Storage.S3.Bucket, "s3Bucket", c.Storage.S3.Bucket, "s3 bucket images are written to")
+	fs.StringVar(&c.Storage.S3.PublicURL, "s3PublicUrl", c.Storage.S3.PublicURL, "public url the s3 bucket is served from, defaults to <s3Endpoint>/<s3Bucket>")
+	fs.IntVar(&c.PageWorkers, "pageWorkers", c.PageWorkers, "number of pages of a chapter downloaded and watermarked concurrently")
+	fs.IntVar(&c.ChapterWorkers, "chapterWorkers", c.ChapterWorkers, "number of chapters of a category processed concurrently")
+	fs.DurationVar((*time.Duration)(&c.Timeouts.Listing), "listingTimeout", time.Duration(c.Timeouts.Listing), "timeout of every fetch of the category listing and of a category's page")
+	fs.DurationVar((*time.Duration)(&c.Timeouts.Page), "pageTimeout", time.Duration(c.Timeouts.Page), "timeout of every fetch of a chapter page")
+	fs.DurationVar((*time.Duration)(&c.Timeouts.Image), "imageTimeout", time.Duration(c.Timeouts.Image), "timeout of every image download")
+	fs.Var(&listFlag{list: &c.Clients.Proxies}, "proxy", "http, https or socks5 proxy url requests are spread across, repeat for several")
+	fs.Var(&listFlag{list: &c.Clients.UserAgents}, "userAgent", "User-Agent sent to sources, repeat for several")
+	fs.IntVar(&c.Clients.MaxFailures, "clientMaxFailures", c.Clients.MaxFailures, "failures in a row after which a proxy or user agent is benched")
+	fs.DurationVar((*time.Duration)(&c.Clients.BenchTime), "clientBenchTime", time.Duration(c.Clients.BenchTime), "how long a failing proxy or user agent is benched")
+	fs.StringVar(&c.Cache.Dir, "cacheDir", c.Cache.Dir, "directory the category listing and category pages are cached in, revalidated with ETag/Last-Modified; empty to disable")
+	fs.BoolVar(&c.Cache.Offline, "offline", c.Cache.Offline, "serve listing pages from -cacheDir only, without requesting them; for list-categories and list-chapters")
+	fs.Float64Var(&c.RateLimit.RPS, "rps", c.RateLimit.RPS, "requests per second allowed to each host, 0 for unlimited")
+	fs.IntVar(&c.RateLimit.Burst, "burst", c.RateLimit.Burst, "number of requests a host may receive in a burst above -rps")
+	fs.DurationVar((*time.Duration)(&c.RateLimit.Jitter), "jitter", time.Duration(c.RateLimit.Jitter), "maximum random delay added before every request")
+	fs.IntVar(&c.Retry.MaxAttempts, "retries", c.Retry.MaxAttempts, "attempts made for every page, chapter and image fetch")
+	fs.DurationVar((*time.Duration)(&c.Retry.BaseDelay), "retryDelay", time.Duration(c.Retry.BaseDelay), "base delay of the exponential backoff between attempts")
+	fs.DurationVar((*time.Duration)(&c.Retry.MaxDelay), "retryMaxDelay", time.Duration(c.Retry.MaxDelay), "maximum delay between attempts")
+	fs.IntVar(&c.MaxJobAttempts, "maxJobAttempts", c.MaxJobAttempts, "failures after which a chapter is dead-lettered and skipped")
+	fs.DurationVar((*time.Duration)(&c.GCGrace), "gcGrace", time.Duration(c.GCGrace), "how old an unreferenced image must be for gc-blobs to delete it")
+	fs.BoolVar(&c.GCDryRun, "dryRun", c.GCDryRun, "make gc-blobs only log the images it would delete")
+	fs.DurationVar((*time.Duration)(&c.ShutdownTimeout), "shutdownTimeout", time.Duration(c.ShutdownTimeout), "how long running chapters may take to finish after SIGINT or SIGTERM before they are rolled back")
+	fs.StringVar(&c.Log.Level, "logLevel", c.Log.Level, "log level: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "logFormat", c.Log.Format, "log format: text or json")
+	fs.DurationVar((*time.Duration)(&c.LeaseTTL), "leaseTTL", time.Duration(c.LeaseTTL), "how long a category lease lasts without a heartbeat")
+}
+
+// validatePostgres reports every problem with the postgres settings, the
+// only ones migrate uses.
+func (c *Config) validatePostgres() error {
+	return invalidConfig(c.postgresProblems())
+}
+
+func (c *Config) postgresProblems() (problems []string) {
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Postgres.Host != "", "postgres.host is required (or POSTGRES_PORT_5432_TCP_ADDR)")
+	check(c.Postgres.DB != "", "postgres.db is required (or POSTGRES_DB)")
+	check(c.Postgres.Port > 0, "postgres.port must be positive, got %v", c.Postgres.Port)
+
+	return
+}
+
+// validate reports every problem with c at once.
+func (c *Config) validate() error {
+	problems := c.postgresProblems()
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.RunMode == "full" || c.RunMode == "top", "runMode must be 'full', 'top' or 'top30', got %q", c.RunMode)
+	check(c.TopN >= 1, "topN must be at least 1, got %v", c.TopN)
+	check(c.Popularity != "file" || c.PopularityFile != "", "popularityFile is required with popularity 'file'")
+	check(c.Popularity != "feed" || c.PopularFeedURL != "", "popularFeedUrl is required with popularity 'feed' (or POPULAR_FEED_URL)")
+	check(c.Popularity == "hits" || c.Popularity == "file" || c.Popularity == "feed", "popularity must be 'hits', 'file' or 'feed', got %q", c.Popularity)
+	check(c.Site != "" || sources[c.Source] != nil, "source must be one of %v, got %q", sourceNames(), c.Source)
+	check(c.Listen != "", "listen is required")
+	check(c.PageWorkers >= 1, "pageWorkers must be at least 1, got %v", c.PageWorkers)
+	check(c.ChapterWorkers >= 1, "chapterWorkers must be at least 1, got %v", c.ChapterWorkers)
+	check(c.Timeouts.Listing > 0, "timeouts.listing must be positive")
+	check(c.Timeouts.Page > 0, "timeouts.page must be positive")
+	check(c.Timeouts.Image > 0, "timeouts.image must be positive")
+	check(c.Clients.MaxFailures >= 1, "clients.maxFailures must be at least 1, got %v", c.Clients.MaxFailures)
+	check(c.Clients.BenchTime > 0, "clients.benchTime must be positive")
+
+	if _, err := c.Clients.identities(); err != nil {
+		problems = append(problems, "clients."+err.Error())
+	}
+
+	check(!c.Cache.Offline || c.Cache.Dir != "", "cache.dir is required with cache.offline")
+	check(c.RateLimit.RPS >= 0, "rateLimit.rps must not be negative")
+	check(c.Retry.MaxAttempts >= 1, "retry.maxAttempts must be at least 1, got %v", c.Retry.MaxAttempts)
+	check(c.MaxJobAttempts >= 1, "maxJobAttempts must be at least 1, got %v", c.MaxJobAttempts)
+	check(c.LeaseTTL > 0, "leaseTTL must be positive")
+	check(c.GCGrace >= 0, "gcGrace must not be negative")
+	check(c.ShutdownTimeout >= 0, "shutdownTimeout must not be negative")
+
+	if _, err := newLogger(ioutil.Discard, c.Log.Level, c.Log.Format); err != nil {
+		problems = append(problems, "log."+err.Error())
+	}
+
+	switch c.Storage.Kind {
+	case "local":
+		check(c.ImageServer != "", "imageServer is required with local storage (or IMAGE_SERVER)")
+		check(c.Storage.Dir != "", "storage.dir is required with local storage")
+	case "s3":
+		_, err := url.ParseRequestURI(c.Storage.S3.Endpoint)
+		check(err == nil, "storage.s3.endpoint must be a url, got %q", c.Storage.S3.Endpoint)
+		check(c.Storage.S3.Bucket != "", "storage.s3.bucket is required with s3 storage")
+	default:
+		problems = append(problems, fmt.Sprintf("storage.kind must be 'local' or 's3', got %q", c.Storage.Kind))
+	}
+
+	return invalidConfig(problems)
+}
+
+func invalidConfig(problems []string) error {
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// ConnString is the lib/pq connection string for the database. lib/pq
+// requires ssl unless sslMode says otherwise.
+func (p PostgresConfig) ConnString() string {
+	u := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(p.User, p.Password),
+		Host:   fmt.Sprintf("%v:%v", p.Host, p.Port),
+		Path:   "/" + p.DB,
+	}
+
+	if p.SSLMode != "" {
+		u.RawQuery = "sslmode=" + url.QueryEscape(p.SSLMode)
+	}
+
+	return u.String()
+}
+
+// String describes the database without its password, for logging.
+func (p PostgresConfig) String() string {
+	return fmt.Sprintf("%v@%v:%v/%v", p.User, p.Host, p.Port, p.DB)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEnv(env map[string]string) func(string) string {
+	return func(name string) string { return env[name] }
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"postgres": {"host": "file-host", "db": "file-db"},
+		"imageServer": "http://file",
+		"pageWorkers": 8,
+		"chapterWorkers": 3,
+		"leaseTTL": "10m",
+		"clients": {"proxies": ["http://file:3128"], "userAgents": ["file-ua"]}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := testEnv(map[string]string{
+		"POSTGRES_PORT_5432_TCP_ADDR": "env-host",
+		"POSTGRES_PORT_5432_TCP_PORT": "6543",
+		"POSTGRES_PASSWORD":           "secret",
+	})
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := loadConfig(fs, []string{"-config", path, "-chapterWorkers", "5", "-proxy", "http://a:3128", "-proxy", "socks5://b:1080", "Naruto"}, env, (*Config).validate)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Postgres.Host != "env-host" || cfg.Postgres.Port != 6543 || cfg.Postgres.DB != "file-db" || cfg.Postgres.Password != "secret" {
+		t.Error(cfg.Postgres)
+	}
+
+	if cfg.PageWorkers != 8 || cfg.ChapterWorkers != 5 {
+		t.Error(cfg.PageWorkers, cfg.ChapterWorkers)
+	}
+
+	if time.Duration(cfg.LeaseTTL) != 10*time.Minute || time.Duration(cfg.Retry.MaxDelay) != time.Minute {
+		t.Error(cfg.LeaseTTL, cfg.Retry.MaxDelay)
+	}
+
+	if !reflect.DeepEqual(cfg.Clients.Proxies, []string{"http://a:3128", "socks5://b:1080"}) || !reflect.DeepEqual(cfg.Clients.UserAgents, []string{"file-ua"}) {
+		t.Error(cfg.Clients)
+	}
+
+	if fs.NArg() != 1 || fs.Arg(0) != "Naruto" {
+		t.Error(fs.Args())
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+
+	cfg := defaultConfig()
+	cfg.PageWorkers = 0
+	cfg.Storage.Kind = "ftp"
+	cfg.Cache.Offline = true
+
+	err := cfg.validate()
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	for _, want := range []string{"pageWorkers", "storage.kind", "cache.dir"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Error(err)
+		}
+	}
+
+	cfg = defaultConfig()
+	cfg.ImageServer = "http://localhost"
+
+	if err := cfg.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+
+	if result := configPath([]string{"-runMode", "top30", "--config=a.json"}); result != "a.json" {
+		t.Error(result)
+	}
+
+	if result := configPath([]string{"-config", "b.json"}); result != "b.json" {
+		t.Error(result)
+	}
+
+	if result := configPath([]string{"--", "-config", "c.json"}); result != "" {
+		t.Error(result)
+	}
+}
+
+func TestPostgresConnString(t *testing.T) {
+
+	p := PostgresConfig{Host: "db", Port: 5432, User: "gomg", Password: "p@ss", DB: "manga", SSLMode: "disable"}
+
+	if result := p.ConnString(); result != "postgresql://gomg:p%40ss@db:5432/manga?sslmode=disable" {
+		t.Error(result)
+	}
+
+	if result := p.String(); strings.Contains(result, "p@ss") {
+		t.Error(result)
+	}
+}
+
+func TestTop30IsTopN(t *testing.T) {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := loadConfig(fs, []string{"-runMode", "top30", "-topN", "5"}, testEnv(map[string]string{"IMAGE_SERVER": "http://localhost"}), (*Config).validate)
+
+	if err != nil || cfg.RunMode != "top" || cfg.TopN != 30 {
+		t.Error(cfg, err)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err = loadConfig(fs, []string{"-runMode", "top", "-popularity", "feed"}, testEnv(map[string]string{"IMAGE_SERVER": "http://localhost"}), (*Config).validate)
+
+	if err == nil || !strings.Contains(err.Error(), "popularFeedUrl") {
+		t.Error(err)
+	}
+}
+
+func TestMigrateOnlyNeedsPostgres(t *testing.T) {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := loadConfig(fs, nil, testEnv(nil), (*Config).validate)
+
+	if err == nil || !strings.Contains(err.Error(), "imageServer") {
+		t.Error(err)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err = loadConfig(fs, nil, testEnv(nil), (*Config).validatePostgres)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err = loadConfig(fs, nil, testEnv(map[string]string{"POSTGRES_PORT_5432_TCP_PORT": "0"}), (*Config).validatePostgres)
+
+	if err == nil || !strings.Contains(err.Error(), "postgres.port") {
+		t.Error(err)
+	}
+}
@@ -1,8 +1,4 @@
-export POSTGRES_USER=xxx
-export POSTGRES_PASSSWORD=xxx
-export POSTGRES_DB=xxx
-export POSTGRES_PORT_5432_TCP_ADDR=xxx
-export POSTGRES_PORT_5432_TCP_PORT=xxx
-export IMAGE_SERVER=https://xxx
+# secrets stay out of the config file
+export POSTGRES_PASSWORD=xxx
 
-go run job.go replaceSpecial.go	--runMode=top30
+go run . -config configs/dev.json
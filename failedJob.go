@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// stages of worker a chapter job can fail at
+const (
+	stageCategory  = "category"
+	stagePages     = "pages"
+	stageChapterNo = "chapterNo"
+	stageSave      = "save"
+)
+
+// DbFailedJob is a chapter job that failed, kept so it can be retried with
+// retry-failed instead of being lost in the logs.
+type DbFailedJob struct {
+	ID           int
+	CategoryName string `sql:"size:512"`
+	CategoryLink string `sql:"size:512"`
+	ChapterName  string `sql:"size:10120"`
+	ChapterLink  string `sql:"size:512;unique_index"`
+	Stage        string `sql:"size:64"`
+	Error        string `sql:"size:10120"`
+	Attempts     int
+	Dead         bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// JobError is a worker failure together with the stage it happened at.
+type JobError struct {
+	Stage string
+	Err   error
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Stage, e.Err)
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// runJob processes a chapter and keeps its DbFailedJob row up to date. A
+// chapter cancelled by shutdown or by losing its category lease is rolled
+// back without counting as a failure.
+func (app *App) runJob(ctx context.Context, job ChapterJobContext) {
+	log := job.logger()
+	log.Info("processing chapter")
+
+	err := app.worker(ctx, job)
+
+	if err == nil {
+		app.clearFailedJob(job)
+		return
+	}
+
+	if ctx.Err() != nil {
+		log.Warn("chapter interrupted, rolled back", "err", err)
+		return
+	}
+
+	log.Error("chapter failed", "stage", jobStage(err), "err", err)
+
+	if err := app.recordFailedJob(job, err); err != nil {
+		log.Error("unable to record failed job", "err", err)
+	}
+}
+
+func (app *App) recordFailedJob(job ChapterJobContext, jobErr error) error {
+	failed := &DbFailedJob{}
+
+	app.DB.Where(&DbFailedJob{ChapterLink: job.Chapter.Link.String()}).First(failed)
+
+	failed.CategoryName = job.Category.Name
+	failed.CategoryLink = job.Category.Link.String()
+	failed.ChapterName = job.Chapter.Name
+	failed.ChapterLink = job.Chapter.Link.String()
+
+	removed := failed.fail(jobErr, app.MaxJobAttempts)
+	chaptersFailed.Inc(failed.Stage)
+
+	if removed {
+		job.logger().Warn("chapter removed upstream, moved to dead-letter")
+	} else if failed.Dead {
+		job.logger().Warn("chapter moved to dead-letter", "attempts", failed.Attempts)
+	}
+
+	return app.DB.Save(failed).Error
+}
+
+// fail records jobErr as the latest failure of f and dead-letters f after
+// maxAttempts failures, or at once when the chapter itself was removed
+// upstream, which it reports.
+func (f *DbFailedJob) fail(jobErr error, maxAttempts int) (removed bool) {
+	f.Stage = jobStage(jobErr)
+	f.Error = jobErr.Error()
+	f.Attempts++
+
+	// a chapter removed upstream will not come back, retrying it is pointless
+	removed = isNotFound(jobErr, f.ChapterLink)
+	f.Dead = removed || f.Attempts >= maxAttempts
+
+	return removed
+}
+
+// jobStage is the stage err happened at, or "unknown" if it is not a JobError.
+func jobStage(err error) string {
+	var jobErr *JobError
+	if errors.As(err, &jobErr) {
+		return jobErr.Stage
+	}
+	return "unknown"
+}
+
+func (app *App) clearFailedJob(job ChapterJobContext) {
+	app.DB.Where(&DbFailedJob{ChapterLink: job.Chapter.Link.String()}).Delete(&DbFailedJob{})
+}
+
+// failedJobs returns the jobs that failed and are not dead-lettered yet.
+func (app *App) failedJobs() (out []ChapterJobContext, err error) {
+	var failed []DbFailedJob
+
+	if err = app.DB.Where("dead = ?", false).Order("id").Find(&failed).Error; err != nil {
+		return nil, err
+	}
+
+	for _, f := range failed {
+		categoryLink, err := url.Parse(f.CategoryLink)
+		if err != nil {
+			slog.Warn("skipping failed job with an invalid link", "id", f.ID, "err", err)
+			continue
+		}
+
+		chapterLink, err := url.Parse(f.ChapterLink)
+		if err != nil {
+			slog.Warn("skipping failed job with an invalid link", "id", f.ID, "err", err)
+			continue
+		}
+
+		out = append(out, ChapterJobContext{
+			Category: Category{Name: f.CategoryName, Link: categoryLink},
+			Chapter:  Chapter{Name: f.ChapterName, Link: chapterLink},
+		})
+	}
+
+	return
+}
+
+// deadChapterLinks returns the links of the chapters of category that
+// exhausted their attempts.
+func (app *App) deadChapterLinks(category Category) (stringSet, error) {
+	var links []string
+
+	err := app.DB.Model(&DbFailedJob{}).Where("dead = ? AND category_name = ?", true, category.Name).Pluck("chapter_link", &links).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return newStringSet(links...), nil
+}
+
+// retryFailedJobs reprocesses every pending failed job once, or until ctx
+// is done; it fails only when the jobs cannot be listed. Like
+// processCategory, the jobs of a category are only run while holding its
+// lease, categories held by another instance are skipped.
+func (app *App) retryFailedJobs(ctx context.Context) error {
+	jobs, err := app.failedJobs()
+
+	if err != nil {
+		return fmt.Errorf("listing failed jobs: %v", err)
+	}
+
+	slog.Info("retrying failed jobs", "count", len(jobs))
+
+	var categories []string
+	byCategory := map[string][]ChapterJobContext{}
+
+	for _, job := range jobs {
+		name := ReplaceSpecial(job.Category.Name)
+		if _, ok := byCategory[name]; !ok {
+			categories = append(categories, name)
+		}
+		byCategory[name] = append(byCategory[name], job)
+	}
+
+	for _, name := range categories {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		app.retryCategoryJobs(ctx, name, byCategory[name])
+	}
+
+	return nil
+}
+
+// retryCategoryJobs leases the category name and reprocesses its failed jobs.
+func (app *App) retryCategoryJobs(ctx context.Context, name string, jobs []ChapterJobContext) {
+	lease, ok, err := app.acquireLease(ctx, name)
+
+	if err != nil {
+		slog.Error("unable to lease category", "category", name, "err", err)
+		return
+	}
+
+	if !ok {
+		slog.Info("category is being processed by another instance, skipping its failed jobs", "category", name)
+		return
+	}
+
+	defer func() {
+		if err := lease.Release(); err != nil {
+			slog.Error("unable to release lease", "category", name, "err", err)
+		}
+	}()
+
+	existing, err := app.existingChaptersInDb(jobs[0].Category)
+
+	if err != nil {
+		slog.Error("unable to list saved chapters", "category", name, "err", err)
+		return
+	}
+
+	work, cancel := app.workContext(ctx)
+	defer cancel()
+
+	work, cancelLease := lease.Context(work)
+	defer cancelLease()
+
+	parallel(len(jobs), app.ChapterWorkers, func(i int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := work.Err(); err != nil {
+			return err
+		}
+
+		if existing.Has(jobs[i].Chapter.Link.String()) {
+			jobs[i].logger().Info("chapter already saved, clearing failed job")
+			app.clearFailedJob(jobs[i])
+			return nil
+		}
+
+		app.runJob(work, jobs[i])
+		return nil
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestJobStage(t *testing.T) {
+
+	err := fmt.Errorf("chapter 12: %w", &JobError{Stage: stagePages, Err: &RetryError{Op: "fetching", Attempts: 4, Err: errors.New("timeout")}})
+
+	if stage := jobStage(err); stage != stagePages {
+		t.Error(stage)
+	}
+
+	if stage := jobStage(errors.New("boom")); stage != "unknown" {
+		t.Error(stage)
+	}
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 4 {
+		t.Error(err)
+	}
+}
+
+func TestFailedJobDeadLettersAfterMaxAttempts(t *testing.T) {
+
+	failed := &DbFailedJob{ChapterLink: "http://www.mangareader.net/naruto/1"}
+	jobErr := &JobError{Stage: stagePages, Err: &ServerError{URL: "http://www.mangareader.net/naruto/1/2", StatusCode: 502}}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		removed := failed.fail(jobErr, 3)
+
+		if removed || failed.Attempts != attempt || failed.Dead != (attempt == 3) || failed.Stage != stagePages {
+			t.Error(attempt, removed, failed)
+		}
+	}
+}
+
+func TestFailedJobDeadLettersChapterRemovedUpstream(t *testing.T) {
+
+	failed := &DbFailedJob{ChapterLink: "http://www.mangareader.net/naruto/1"}
+
+	// a missing page of the chapter is retried
+	missingPage := &JobError{Stage: stagePages, Err: &NotFoundError{URL: "http://www.mangareader.net/naruto/1/2"}}
+
+	if removed := failed.fail(missingPage, 5); removed || failed.Dead {
+		t.Error(removed, failed)
+	}
+
+	removedChapter := &JobError{Stage: stagePages, Err: &RetryError{Op: "fetching", Attempts: 1, Err: &NotFoundError{URL: failed.ChapterLink}}}
+
+	if removed := failed.fail(removedChapter, 5); !removed || !failed.Dead || failed.Attempts != 2 {
+		t.Error(removed, failed)
+	}
+}
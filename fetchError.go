@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NotFoundError is returned when a page or image no longer exists upstream,
+// either by its status code or because the site served its not found page.
+type NotFoundError struct {
+	URL string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%v not found", e.URL)
+}
+
+// RateLimitedError is returned for a 429 or 503 response. The host is paused
+// for RetryAfter before it is sent another request.
+type RateLimitedError struct {
+	URL        string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%v rate limited with %v, retry after %v", e.URL, e.StatusCode, e.RetryAfter)
+}
+
+// ServerError is returned for any other 5xx response.
+type ServerError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%v responded %v", e.URL, e.Status)
+}
+
+// StatusError is returned for any other response that is not a success.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v responded %v", e.URL, e.Status)
+}
+
+// ParseError is returned when a response was received but its content is
+// not what the site definition expects: html without the selected element,
+// an undecodable image, a chapter without pages.
+type ParseError struct {
+	URL string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parsing %v: %v", e.URL, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// statusError is the error for a response with statusCode, nil for a
+// success.
+func statusError(url string, statusCode int, status string) error {
+	switch {
+	case statusCode == 404 || statusCode == 410:
+		return &NotFoundError{URL: url}
+	case statusCode == 429 || statusCode == 503:
+		return &RateLimitedError{URL: url, StatusCode: statusCode}
+	case statusCode >= 500:
+		return &ServerError{URL: url, StatusCode: statusCode, Status: status}
+	case statusCode >= 400:
+		return &StatusError{URL: url, StatusCode: statusCode, Status: status}
+	}
+	return nil
+}
+
+// isNotFound reports whether err is, or wraps, a NotFoundError for url.
+func isNotFound(err error, url string) bool {
+	var notFound *NotFoundError
+	return errors.As(err, &notFound) && notFound.URL == url
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+
+	for code, expected := range map[int]error{
+		200: nil,
+		404: &NotFoundError{URL: "http://a.com/1"},
+		410: &NotFoundError{URL: "http://a.com/1"},
+		429: &RateLimitedError{URL: "http://a.com/1", StatusCode: 429},
+		503: &RateLimitedError{URL: "http://a.com/1", StatusCode: 503},
+		502: &ServerError{URL: "http://a.com/1", StatusCode: 502, Status: "status"},
+		403: &StatusError{URL: "http://a.com/1", StatusCode: 403, Status: "status"},
+	} {
+		if result := statusError("http://a.com/1", code, "status"); !reflect.DeepEqual(result, expected) {
+			t.Error(code, result)
+		}
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+
+	err := &JobError{Stage: stagePages, Err: &RetryError{Op: "fetching", Attempts: 1, Err: &NotFoundError{URL: "http://a.com/naruto/1"}}}
+
+	if !isNotFound(err, "http://a.com/naruto/1") {
+		t.Error(err)
+	}
+
+	if isNotFound(err, "http://a.com/naruto/2") {
+		t.Error("a missing page is not a missing chapter")
+	}
+
+	if isNotFound(fmt.Errorf("wrapped: %w", errors.New("boom")), "http://a.com/naruto/1") {
+		t.Error("expected only NotFoundErrors to match")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	defaultPopularDays  = 7
+	defaultPopularLimit = 30
+	maxHitDays          = 365
+)
+
+// DbHit counts the views of a chapter on one day (UTC).
+type DbHit struct {
+	ID          int
+	DbChapterID int       `sql:"unique_index:uix_db_hit_chapter_day"`
+	Day         time.Time `sql:"type:date;unique_index:uix_db_hit_chapter_day"`
+	Count       int
+}
+
+type APIHits struct {
+	ChapterID int    `json:"chapterId"`
+	Day       string `json:"day"`
+	Count     int    `json:"count"`
+}
+
+type APIPopularCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Hits int    `json:"hits"`
+}
+
+func hitDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// recordHit atomically adds a view of chapterID to today's count and
+// returns the new count.
+func (app *App) recordHit(chapterID int, now time.Time) (int, error) {
+	var count int
+
+	err := app.DB.Raw(`INSERT INTO db_hit (db_chapter_id, day, count) VALUES (?, ?, 1)
+		ON CONFLICT (db_chapter_id, day) DO UPDATE SET count = db_hit.count + 1
+		RETURNING count`, chapterID, hitDay(now)).Row().Scan(&count)
+
+	return count, err
+}
+
+// chapterHits returns the daily counts of chapterID over the last days days,
+// oldest first. Days without views are left out.
+func (app *App) chapterHits(chapterID int, days int, now time.Time) ([]APIHits, error) {
+	rows, err := app.DB.Raw(`SELECT to_char(day, 'YYYY-MM-DD'), count FROM db_hit
+		WHERE db_chapter_id = ? AND day > ? ORDER BY day`,
+		chapterID, hitDay(now.AddDate(0, 0, -days))).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	hits := make([]APIHits, 0)
+
+	for rows.Next() {
+		h := APIHits{ChapterID: chapterID}
+		if err := rows.Scan(&h.Day, &h.Count); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+
+	return hits, rows.Err()
+}
+
+// popularCategories ranks categories by the views of their chapters over
+// the last days days.
+func (app *App) popularCategories(days int, limit int, now time.Time) ([]APIPopularCategory, error) {
+	popular := make([]APIPopularCategory, 0)
+
+	err := app.DB.Raw(`SELECT c.id, c.name, sum(h.count) AS hits
+		FROM db_hit h
+		JOIN db_chapter ch ON ch.id = h.db_chapter_id
+		JOIN db_category c ON c.id = ch.db_category_id
+		WHERE h.day > ?
+		GROUP BY c.id, c.name
+		ORDER BY hits DESC, c.name
+		LIMIT ?`, hitDay(now.AddDate(0, 0, -days)), limit).Scan(&popular).Error
+
+	return popular, err
+}
+
+func daysParam(r *http.Request, fallback int) (int, error) {
+	days, err := intParam(r, "days", fallback)
+	if err != nil {
+		return 0, err
+	}
+
+	if days < 1 || days > maxHitDays {
+		return 0, badRequest("days must be between 1 and %v", maxHitDays)
+	}
+
+	return days, nil
+}
+
+// recordHitAPI serves POST /chapters/{id}/hits, called by frontends every
+// time a chapter is viewed.
+func (app *App) recordHitAPI(r *http.Request) (interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.exists(&DbChapter{}, id, "chapter"); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	count, err := app.recordHit(id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return APIHits{ChapterID: id, Day: hitDay(now), Count: count}, nil
+}
+
+// chapterHitsAPI serves GET /chapters/{id}/hits?days=.
+func (app *App) chapterHitsAPI(r *http.Request) (interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := daysParam(r, 30)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.exists(&DbChapter{}, id, "chapter"); err != nil {
+		return nil, err
+	}
+
+	return app.chapterHits(id, days, time.Now())
+}
+
+// popularAPI serves GET /popular?days=&limit=.
+func (app *App) popularAPI(r *http.Request) (interface{}, error) {
+	days, err := daysParam(r, defaultPopularDays)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := intParam(r, "limit", defaultPopularLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit < 1 || limit > maxPageLimit {
+		return nil, badRequest("limit must be between 1 and %v", maxPageLimit)
+	}
+
+	return app.popularCategories(days, limit, time.Now())
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHitDayIsUTC(t *testing.T) {
+
+	singapore := time.FixedZone("SGT", 8*60*60)
+
+	if result := hitDay(time.Date(2020, 3, 1, 7, 0, 0, 0, singapore)); result != "2020-02-29" {
+		t.Error(result)
+	}
+
+	if result := hitDay(time.Date(2020, 3, 1, 9, 0, 0, 0, singapore)); result != "2020-03-01" {
+		t.Error(result)
+	}
+}
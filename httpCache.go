@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HTTPCache keeps listing pages on disk with their ETag and Last-Modified so
+// refetching an unchanged page costs a 304. Every page is stored as
+// <sha256 of url>.html, next to a .json with its url and validators. When
+// Offline, pages are only read from it, which replays a captured crawl.
+type HTTPCache struct {
+	Dir     string
+	Offline bool
+}
+
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+// NotCachedError is returned offline for a page the cache does not have.
+type NotCachedError struct {
+	URL string
+}
+
+func (e *NotCachedError) Error() string {
+	return fmt.Sprintf("%v is not cached, cannot fetch it offline", e.URL)
+}
+
+// fetch returns the body at url, sending the validators of the cached copy
+// so an unchanged page is answered with a 304 and served from disk. Requests
+// are throttled by limiter.
+func (c *HTTPCache) fetch(ctx context.Context, limiter *HostLimiter, client http.Client, url string) ([]byte, error) {
+	entry, body, err := c.load(url)
+
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("ignoring unreadable cache entry", "url", url, "err", err)
+		entry = nil
+	}
+
+	if c.Offline {
+		if entry == nil {
+			httpCacheResults.Inc("offline_miss")
+			return nil, &NotCachedError{URL: url}
+		}
+
+		httpCacheResults.Inc("offline_hit")
+		return body, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Close = true
+
+	if entry != nil {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	res, err := doRequest(limiter, client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && entry != nil {
+		httpCacheResults.Inc("hit")
+		return body, nil
+	}
+
+	body, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpCacheResults.Inc("miss")
+
+	entry = &cacheEntry{
+		URL:          url,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}
+
+	if err := c.store(entry, body); err != nil {
+		slog.Warn("unable to cache page", "url", url, "err", err)
+	}
+
+	return body, nil
+}
+
+func (c *HTTPCache) path(url string, ext string) string {
+	return filepath.Join(c.Dir, sha256Hex([]byte(url))+ext)
+}
+
+// load returns the cached copy of url, or an error satisfying os.IsNotExist
+// when there is none.
+func (c *HTTPCache) load(url string) (*cacheEntry, []byte, error) {
+	meta, err := ioutil.ReadFile(c.path(url, ".json"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(meta, entry); err != nil {
+		return nil, nil, err
+	}
+
+	body, err := ioutil.ReadFile(c.path(url, ".html"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entry, body, nil
+}
+
+// store writes the body before the entry, each through a rename, so an
+// entry is never read with a partial or missing body.
+func (c *HTTPCache) store(entry *cacheEntry, body []byte) error {
+	if err := os.MkdirAll(c.Dir, 0777); err != nil {
+		return err
+	}
+
+	meta, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(c.path(entry.URL, ".html"), body); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path(entry.URL, ".json"), meta)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestHTTPCacheRevalidates(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	full := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/etag" && r.Header.Get("If-None-Match") == `"v1"` ||
+			r.URL.Path == "/modified" && r.Header.Get("If-Modified-Since") == "Tue, 08 Aug 2017 00:00:00 GMT" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		full++
+
+		if r.URL.Path == "/etag" {
+			w.Header().Set("ETag", `"v1"`)
+		} else {
+			w.Header().Set("Last-Modified", "Tue, 08 Aug 2017 00:00:00 GMT")
+		}
+		w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+	}))
+	defer server.Close()
+
+	cache := &HTTPCache{Dir: dir}
+
+	for _, path := range []string{"/etag", "/modified"} {
+		for i := 0; i < 2; i++ {
+			body, err := cache.fetch(context.Background(), nil, http.Client{}, server.URL+path)
+
+			if err != nil || string(body) != "<p>"+path+"</p>" {
+				t.Error(path, i, string(body), err)
+			}
+		}
+	}
+
+	if full != 2 {
+		t.Error("unchanged pages should be answered with a 304", full)
+	}
+
+	server.Close()
+
+	offline := &HTTPCache{Dir: dir, Offline: true}
+
+	body, err := offline.fetch(context.Background(), nil, http.Client{}, server.URL+"/etag")
+
+	if err != nil || string(body) != "<p>/etag</p>" {
+		t.Error(string(body), err)
+	}
+
+	_, err = offline.fetch(context.Background(), nil, http.Client{}, server.URL+"/naruto")
+
+	var notCached *NotCachedError
+	if !errors.As(err, &notCached) || isRetryable(err) {
+		t.Error(err)
+	}
+}
@@ -1,25 +1,27 @@
+// The api and /metrics routes use method and wildcard patterns, which GOPATH
+// builds would otherwise turn off with the Go 1.20 GODEBUG defaults.
+//go:debug httpmuxgo121=0
+
 package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"flag"
-	"fmt"
-	"hash/fnv"
 	"image"
 	"image/draw"
 	"image/jpeg"
 	"image/png"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -27,19 +29,6 @@ import (
 	_ "github.com/lib/pq"
 )
 
-var postgresConnString string = "postgresql://" + os.Getenv("POSTGRES_USER") + ":" + os.Getenv("POSTGRES_PASSWORD") + "@" + os.Getenv("POSTGRES_PORT_5432_TCP_ADDR") + "/" + os.Getenv("POSTGRES_DB")
-
-var imageServer = os.Getenv("IMAGE_SERVER")
-
-var popularFeedAddr = "http://xxx/api/feeds/popular"
-
-const (
-	root                = "http://www.mangareader.net"
-	accountName         = "xxx"
-	accountKey          = "xxx"
-	containerAccessType = "blob"
-)
-
 type Category struct {
 	Name string
 	Link *url.URL
@@ -56,6 +45,11 @@ type ChapterJobContext struct {
 	Pages    []Page
 }
 
+// logger returns the default logger with the fields identifying job.
+func (job ChapterJobContext) logger() *slog.Logger {
+	return slog.With("category", job.Category.Name, "chapter", job.Chapter.Name, "link", job.Chapter.Link.String())
+}
+
 type Page struct {
 	PageNo        int
 	Link          *url.URL
@@ -96,13 +90,13 @@ type DbGenre struct {
 type DbChapter struct {
 	ID           int
 	Name         string `sql:"size:10120"`
-	Link         string `sql:"size:512"`
+	Link         string `sql:"size:512;unique_index:uix_db_chapter_category_link"`
 	ChapterNo    int
 	TotalPages   int
 	ScrappedTime int64
 	Pages        []DbPage
 	DbCategory   DbCategory
-	DbCategoryID int
+	DbCategoryID int `sql:"unique_index:uix_db_chapter_category_link"`
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
@@ -118,245 +112,158 @@ type DbPage struct {
 	UpdatedAt      time.Time
 }
 
-type DbHit struct {
-	ID          int
-	Count       int
-	ChapterName string `sql:"size:10512"`
-}
-
 type DbCategoryProcessing struct {
 	ID           int
-	CategoryName string `sql:"size:10512"`
+	CategoryName string `sql:"size:10512;unique_index"`
+	Owner        string `sql:"size:512"`
+	ExpiresAt    time.Time
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
 
-type CategoryFromFeedServer struct {
-	CategoryName string `json:"manga_name"`
-}
-
-var https chan http.Client
-
-func acquire() (c http.Client) {
-	c = <-https
-	return
-}
-
-func release(c http.Client) {
-	https <- c
-}
-
-var db *gorm.DB
-
 func init() {
-
-	log.Println("running")
-	if imageServer == "" {
-		panic("ENV VAR IMAGE_SERVER NOT SET")
-	}
-
-	initHttpClients()
-
-	log.Println(postgresConnString)
-
-	gormDb, err := gorm.Open("postgres", postgresConnString)
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	db = &gormDb
-
-	db.DB().Ping()
-
-	db.DB().SetMaxIdleConns(-1)
-	db.DB().SetMaxOpenConns(-1)
-
-	db.SingularTable(true)
-
 	rand.Seed(time.Now().UnixNano())
 }
 
-func unmount() {
-	exec.Command("umount", []string{"./images"}...)
-}
+// openDb connects to the database described by cfg.
+func openDb(cfg PostgresConfig) (*gorm.DB, error) {
+	slog.Info("connecting to database", "db", cfg.String())
 
-func mount() {
-	var cmdOut []byte
-	var err error
+	gormDb, err := gorm.Open("postgres", cfg.ConnString())
 
-	if cmdOut, err = exec.Command("sshfs", []string{"root@xxx.xxx.xxx.xxx/images", "./images", "-o", "nonempty"}...).Output(); err != nil {
-		panic("unable to mount")
+	if err != nil {
+		return nil, err
 	}
 
-	log.Println(string(cmdOut))
-}
+	if err := gormDb.DB().Ping(); err != nil {
+		return nil, err
+	}
 
-func initHttpClients() {
+	gormDb.DB().SetMaxIdleConns(-1)
+	gormDb.DB().SetMaxOpenConns(-1)
 
-	httpTimeout := 2 * time.Minute
+	gormDb.SingularTable(true)
 
-	slice := make([]http.Client, 0)
+	return gormDb, nil
+}
 
-	slice = append(slice, http.Client{Timeout: httpTimeout})
+func main() {
 
-	log.Printf("there are %v available http clients for use \n", len(slice))
+	command, args := parseCommand(os.Args[1:])
 
-	https = make(chan http.Client, len(slice))
+	flag.Usage = usage
 
-	for _, c := range slice {
-		https <- c
+	cmd, ok := commands[command]
+	if !ok && command != "migrate" {
+		defaults := defaultConfig()
+		defaults.bindFlags(flag.CommandLine)
+		usage()
+		os.Exit(2)
 	}
-}
 
-func createBucketFolder() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	for i := 0; i < 100; i++ {
-		path := fmt.Sprintf("images/%v", i)
-		os.MkdirAll(path, 0777)
+	validate := (*Config).validate
+	if command == "migrate" {
+		validate = (*Config).validatePostgres
 	}
-}
-
-func main() {
-
-	runModePtr := flag.String("runMode", "full", "run mode: either 'full' or 'top30' only")
-	isReversePtr := flag.Bool("isReverse", false, "run reverse?")
 
-	flag.Parse()
+	cfg, err := loadConfig(flag.CommandLine, args, os.Getenv, validate)
 
-	log.Println("runMode:", *runModePtr)
-	log.Println("isReverse:", *isReversePtr)
-
-	for {
-		log.Println("running job")
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
 
-		categories, err := getCategoriesFromSite()
+	logger, err := newLogger(os.Stderr, cfg.Log.Level, cfg.Log.Format)
 
-		if err != nil {
-			time.Sleep(5 * time.Minute)
-			continue
-		}
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
 
-		if *runModePtr == "top30" {
-			categories = filterToTop30(categories)
-		}
+	slog.SetDefault(logger)
 
-		fmt.Printf("number of categories to process %v\n", len(categories))
+	if command == "migrate" {
+		db, err := openDb(cfg.Postgres)
 
 		if err != nil {
-			time.Sleep(5 * time.Minute)
-			continue
+			fatal("unable to connect to database", err)
 		}
 
-		var isReverse = *isReversePtr
-
-		if isReverse {
-			log.Println("running in reverse")
-			categories = reverse(categories)
+		if err := runMigrate(db, flag.Args()); err != nil {
+			fatal("migration failed", err)
 		}
+		return
+	}
 
-		for _, category := range categories {
-
-			cat := category
-
-			// check if category was already processing, if it is, go to next loop.
-			dbCategoryProcessing := &DbCategoryProcessing{}
-			queryCategoryName := ReplaceSpecial(cat.Name)
-			db.Where(&DbCategoryProcessing{CategoryName: queryCategoryName}).First(dbCategoryProcessing)
-
-			if dbCategoryProcessing.CategoryName == queryCategoryName {
-				log.Println("category " + queryCategoryName + " is in processing state")
-				time.Sleep(3 * time.Second)
-				continue
-			}
-
-			log.Println("picked " + cat.Name + " to process")
-
-			// set category to be in processing state
-			dbCategoryProcessing.CategoryName = queryCategoryName
-			db.Create(dbCategoryProcessing)
+	if len(flag.Args()) != cmd.Args {
+		usage()
+		os.Exit(2)
+	}
 
-			log.Println("have set " + cat.Name + " to processing")
+	if cfg.Cache.Offline && !cmd.Offline {
+		fatal("invalid configuration", errors.New(command+" cannot run -offline, only "+strings.Join(offlineCommands(), ", ")+" can"))
+	}
 
-			for _, job := range getNewJobs(cat) {
-				job := job
-				log.Println("new job received ", job.Chapter.Name)
-				worker(job)
-			}
+	var db *gorm.DB
 
-			log.Println("completed processing category " + cat.Name)
+	if cmd.needsDB(cfg) {
+		db, err = openDb(cfg.Postgres)
 
-			log.Println("unlocking")
-			log.Println(dbCategoryProcessing)
-			// unlock category
-			db.Delete(dbCategoryProcessing)
+		if err != nil {
+			fatal("unable to connect to database", err)
 		}
 	}
-}
-
-func filterToTop30(categories []Category) (result []Category) {
-	log.Println("geting top 30 only")
 
-	res, err := http.Get(popularFeedAddr)
-	if err != nil {
-		log.Fatal(err)
-	}
+	slog.Info("starting", "command", command, "runMode", cfg.RunMode, "topN", cfg.TopN, "isReverse", cfg.IsReverse)
 
-	body, err := ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
+	app, err := newApp(cfg, db)
 
 	if err != nil {
-		log.Fatal(err)
+		fatal("unable to start", err)
 	}
 
-	var dat []CategoryFromFeedServer
-	err = json.Unmarshal(body, &dat)
-	if err != nil {
-		log.Fatal(err)
+	if app.MetricsListen != "" && command != "serve" {
+		serveMetrics(app.MetricsListen)
 	}
 
-	for _, cat := range categories {
-		if feedsContainCategory(dat, cat) {
-			result = append(result, cat)
-		}
-	}
+	err = cmd.Run(ctx, app, CrawlOptions{RunMode: cfg.RunMode, TopN: cfg.TopN, IsReverse: cfg.IsReverse}, flag.Args())
 
-	return
-}
+	if ctx.Err() != nil {
+		slog.Info("shut down", "command", command)
+		return
+	}
 
-func getJson(url string, target interface{}) error {
-	r, err := http.Get(url)
 	if err != nil {
-		return err
+		fatal(command+" failed", err)
 	}
-	defer r.Body.Close()
-
-	return json.NewDecoder(r.Body).Decode(target)
 }
 
-func worker(job ChapterJobContext) {
+// worker downloads, stores and saves the chapter of job. When ctx is done
+// it stops between pages. The images of a chapter that is not saved are left
+// in the store, they may be shared with other chapters; gc-blobs removes
+// them once nothing references them.
+func (app *App) worker(ctx context.Context, job ChapterJobContext) error {
+	ctx = withLogger(ctx, job.logger())
 
-	dbCategory, err := getDbCategory(job.Category)
+	dbCategory, err := app.getDbCategory(ctx, job.Category)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return &JobError{Stage: stageCategory, Err: err}
 	}
 
-	dbPages, err := processPages(job.Chapter)
+	chapterNo := strings.Replace(strings.TrimSpace(job.Chapter.Name), strings.TrimSpace(job.Category.Name), "", -1)
+
+	intChapterNo, err := strconv.Atoi(strings.TrimSpace(chapterNo))
 
 	if err != nil {
-		log.Println(err)
-		return
+		return &JobError{Stage: stageChapterNo, Err: err}
 	}
 
-	chapterNo := strings.Replace(strings.TrimSpace(job.Chapter.Name), strings.TrimSpace(job.Category.Name), "", -1)
-
-	intChapterNo, err := strconv.Atoi(strings.TrimSpace(chapterNo))
+	dbPages, err := app.processPages(ctx, job)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return &JobError{Stage: stagePages, Err: err}
 	}
 
 	dbChapter := &DbChapter{
@@ -369,207 +276,189 @@ func worker(job ChapterJobContext) {
 		ScrappedTime: time.Now().Unix(),
 	}
 
-	db.Create(dbChapter)
+	if err := ctx.Err(); err != nil {
+		return &JobError{Stage: stageSave, Err: err}
+	}
+
+	if err := app.saveChapter(dbChapter); err != nil {
+		return &JobError{Stage: stageSave, Err: err}
+	}
+
+	chaptersSaved.Inc()
+
+	job.logger().Info("saved chapter", "pages", len(dbPages))
 
-	log.Println("success when saving for ", dbChapter.Name)
+	return nil
 }
 
-func processPages(chapter Chapter) (dbPages []DbPage, err error) {
-	pages, err := pagesFromChapter(chapter)
+// saveChapter saves a chapter and its pages in one transaction so a
+// partially saved chapter is never taken as already scraped.
+func (app *App) saveChapter(dbChapter *DbChapter) error {
+	tx := app.DB.Begin()
 
-	if err != nil {
-		log.Println(err)
-		return
+	if tx.Error != nil {
+		return tx.Error
 	}
 
-	if err != nil {
-		return dbPages, err
+	if err := tx.Create(dbChapter).Error; err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	pageWorkerResults := make(chan PageWorkerResult, len(pages))
+	return tx.Commit().Error
+}
 
-	for _, page := range pages {
+// processPages stores the images of every page of the job's chapter.
+func (app *App) processPages(ctx context.Context, job ChapterJobContext) (dbPages []DbPage, err error) {
+	pages, err := app.Source.Pages(ctx, job.Chapter)
 
-		pageWorker(page, pageWorkerResults)
+	if err != nil {
+		return
 	}
 
-	for i := 0; i < len(pages); i++ {
-		r := <-pageWorkerResults
-		if r.Err != nil {
-			err = r.Err
-			log.Println(err)
-			return dbPages, err
+	log := job.logger()
+	pageWorkerResults := make([]PageWorkerResult, len(pages))
+
+	err = parallel(len(pages), app.PageWorkers, func(i int) error {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		pageLog := log.With("page", pages[i].PageNo)
+		pageWorkerResults[i] = app.pageWorker(withLogger(ctx, pageLog), pageLog, pages[i])
+		return pageWorkerResults[i].Err
+	})
+
+	if err != nil {
+		return nil, err
+	}
 
+	for _, r := range pageWorkerResults {
 		dbPages = append(dbPages, r.Val)
 	}
 
 	return
 }
 
-func pageWorker(p Page, result chan<- PageWorkerResult) {
-
-	mangaSrc, err := mangaSrcFromPage(p.Link)
+func (app *App) pageWorker(ctx context.Context, log *slog.Logger, p Page) PageWorkerResult {
 
-	if err != nil || mangaSrc == nil {
-		result <- PageWorkerResult{Val: DbPage{}, Err: err}
-		return
+	fail := func(err error) PageWorkerResult {
+		log.Warn("page failed", "err", err)
+		return PageWorkerResult{Val: DbPage{}, Err: err}
 	}
 
-	imgb, err := watermark(mangaSrc)
+	mangaSrc, err := app.Source.ImageSrc(ctx, p)
 
-	if err != nil {
-		result <- PageWorkerResult{Val: DbPage{}, Err: err}
-		return
-	}
-
-	uuid, err := uuid.NewV4()
-	if err != nil {
-		result <- PageWorkerResult{Val: DbPage{}, Err: err}
-		return
+	if err != nil || mangaSrc == nil {
+		return fail(err)
 	}
 
-	hashCode := hash(uuid.String())
-	bucketNum := hashCode % 100
-	hostedMangaSrc := fmt.Sprintf("%v/%v.jpg", bucketNum, strings.Replace(uuid.String(), "-", "", -1))
-	path := fmt.Sprintf("images/%v", hostedMangaSrc)
-
-	file, err := os.Create(path)
+	imgb, err := app.watermark(ctx, mangaSrc)
 
 	if err != nil {
-		result <- PageWorkerResult{Val: DbPage{}, Err: err}
-		return
+		return fail(err)
 	}
 
-	_, err = file.Write(imgb)
-	file.Close()
-
-	log.Printf("written %v to disk \n", path)
+	blob, err := putContent(app.Store, imgb, "image/jpeg")
 
 	if err != nil {
-		result <- PageWorkerResult{Val: DbPage{}, Err: err}
-		return
+		return fail(err)
 	}
 
-	absUrl := fmt.Sprintf("%v/%v", imageServer, path)
-	mp := DbPage{MangaSrc: mangaSrc.String(), PageNo: p.PageNo, HostedMangaSrc: absUrl}
+	pagesFetched.Inc()
 
-	result <- PageWorkerResult{Val: mp, Err: nil}
-}
+	log.Debug("stored page", "url", blob.URL)
 
-func newDocument(c http.Client, url string) (doc *goquery.Document, err error) {
+	mp := DbPage{MangaSrc: mangaSrc.String(), PageNo: p.PageNo, HostedMangaSrc: blob.URL}
 
-	req, err := http.NewRequest("GET", url, nil)
+	return PageWorkerResult{Val: mp, Err: nil}
+}
 
-	req.Close = true
+// newDocument fetches and parses the html at url with c, a client of
+// clients, throttled and retried the way clients are. Every attempt is
+// bounded by timeout as well as ctx. The page goes through cache unless it
+// is nil.
+func newDocument(ctx context.Context, clients *ClientPool, c http.Client, url string, timeout time.Duration, cache *HTTPCache) (doc *goquery.Document, err error) {
+	err = clients.Retry.Do(ctx, "fetching "+url, func() error {
+		attemptCtx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
 
-	res, err := c.Do(req)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+		if cache != nil {
+			doc, err = fetchCachedDocument(attemptCtx, clients.Limiter, c, url, cache)
+		} else {
+			doc, err = fetchDocument(attemptCtx, clients.Limiter, c, url)
+		}
+		return err
+	})
 
-	defer res.Body.Close()
+	return
+}
 
-	doc, err = goquery.NewDocumentFromReader(res.Body)
+func fetchCachedDocument(ctx context.Context, limiter *HostLimiter, c http.Client, url string, cache *HTTPCache) (*goquery.Document, error) {
+	body, err := cache.fetch(ctx, limiter, c, url)
 
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
-	return
+	return goquery.NewDocumentFromReader(bytes.NewReader(body))
 }
 
-func mangaSrcFromPage(pageLink *url.URL) (url *url.URL, err error) {
-	c := acquire()
-	defer release(c)
+func fetchDocument(ctx context.Context, limiter *HostLimiter, c http.Client, url string) (doc *goquery.Document, err error) {
 
-	doc, err := newDocument(c, pageLink.String())
-	if err != nil {
-		return
-	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 
-	docHTML, err := doc.Html()
 	if err != nil {
-		return
-	}
-
-	if docHTML == "<html><head></head><body><h1>404 Not Found</h1></body></html>" {
-		err = errors.New("404 respons error from page")
-		return
+		return nil, err
 	}
 
-	element := doc.Find("div#imgholder img#img").First()
-	value, isExist := element.Attr("src")
-	if !isExist {
-		return nil, errors.New("cannot find img src on page")
-	}
+	req.Close = true
 
-	url, err = url.Parse(value)
+	res, err := doRequest(limiter, c, req)
 	if err != nil {
 		return
 	}
 
-	return url, nil
-}
-
-func pagesFromChapter(chapter Chapter) (pages []Page, err error) {
-	c := acquire()
-	defer release(c)
-
-	doc, err := newDocument(c, chapter.Link.String())
-	if err != nil {
-		log.Println(err)
-		return pages, err
-	}
+	defer res.Body.Close()
 
-	docHtml, err := doc.Html()
+	doc, err = goquery.NewDocumentFromReader(res.Body)
 
-	if err != nil {
-		log.Println(err)
-		return pages, err
-	}
+	return
+}
 
-	if docHtml == "<html><head></head><body><h1>404 Not Found</h1></body></html>" {
-		err = errors.New("404 error when get pages of a chapter from mangareader")
-		return pages, err
+// withTimeout derives the context of a single fetch attempt, a zero timeout
+// leaving it bounded by ctx only.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-
-	doc.Find("select option").Each(func(i int, element *goquery.Selection) {
-		value, isExist := element.Attr("value")
-		if isExist {
-			link, err := url.Parse(root + value)
-			if err == nil {
-				page := Page{PageNo: i + 1, Link: link}
-				pages = append(pages, page)
-			}
-		}
-	})
-
-	log.Println("found ", len(pages), " for ", chapter.Name)
-
-	return
+	return context.WithTimeout(ctx, timeout)
 }
 
-func getNewJobs(category Category) (out []ChapterJobContext) {
-	fromSite, err := chaptersFromSite(category)
+func (app *App) getNewJobs(ctx context.Context, category Category) (out []ChapterJobContext) {
+	log := slog.With("category", category.Name)
+	ctx = withLogger(ctx, log)
+
+	fromSite, err := app.Source.Chapters(ctx, category)
 
 	if err != nil {
-		log.Println(err)
+		log.Error("unable to list chapters", "err", err)
 		return
 	}
 
-	existingChapters, err := existingChaptersInDb(category)
+	existingChapters, err := app.existingChaptersInDb(category)
 	if err != nil {
-		log.Println(err)
+		log.Error("unable to list saved chapters", "err", err)
 		return
 	}
 
-	newChapters := except(chapterNamesFromSite(fromSite), existingChapters)
+	deadLinks, err := app.deadChapterLinks(category)
+	if err != nil {
+		log.Error("unable to list dead-lettered chapters", "err", err)
+		return
+	}
 
-	for _, chapter := range fromSite {
-		if contains(newChapters, chapter.Name) {
+	for _, chapter := range newChapters(fromSite, existingChapters) {
+		if !deadLinks.Has(chapter.Link.String()) {
 			out = append(out, ChapterJobContext{Category: category, Chapter: chapter})
 		}
 	}
@@ -577,76 +466,47 @@ func getNewJobs(category Category) (out []ChapterJobContext) {
 	return
 }
 
-func chapterNamesFromSite(chapters []Chapter) []string {
-
-	out := make([]string, len(chapters))
-	for i, chapter := range chapters {
-		out[i] = strings.TrimSpace(chapter.Name)
+// newChapters returns the chapters from the site whose link is not in existing.
+func newChapters(fromSite []Chapter, existing stringSet) (out []Chapter) {
+	for _, chapter := range fromSite {
+		if !existing.Has(chapter.Link.String()) {
+			out = append(out, chapter)
+		}
 	}
-	return out
+	return
 }
 
-func getDbCategory(in Category) (out *DbCategory, err error) {
-	c := acquire()
-	defer release(c)
+func (app *App) getDbCategory(ctx context.Context, in Category) (out *DbCategory, err error) {
+	app.categoryMu.Lock()
+	defer app.categoryMu.Unlock()
 
 	dbCategory := &DbCategory{}
 
 	queryCategoryName := ReplaceSpecial(in.Name)
 
-	db.Where(&DbCategory{Name: queryCategoryName}).First(dbCategory)
-
-	log.Printf("queried cat : %+v\n", dbCategory)
+	app.DB.Where(&DbCategory{Name: queryCategoryName}).First(dbCategory)
 
 	if dbCategory.Name == queryCategoryName {
 		out = dbCategory
-		log.Println("found dbCategory in database ", out.Name)
+		slog.Debug("found category in database", "category", out.Name, "id", out.ID)
 		return
 	}
 
-	log.Println("dbCategory not found in database", in.Name)
+	slog.Info("category not in database, saving it", "category", in.Name)
 
-	doc, err := newDocument(c, in.Link.String())
-	if err != nil {
-		return
-	}
-
-	categoryImgElement := doc.Find("div#mangaimg img").First()
-	categoryImg, ok := categoryImgElement.Attr("src")
-	if !ok {
-		err := errors.New("cannot find category img")
-		return nil, err
-	}
-	categoryImgUrl, err := url.Parse(categoryImg)
+	metadata, err := app.Source.Metadata(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 
-	altNameElement := doc.Find("div#mangaproperties table tbody tr:nth-child(2) td:nth-child(2)").First()
-	altName := altNameElement.Text()
-
-	yearOfReleaseElement := doc.Find("div#mangaproperties table tbody tr:nth-child(3) td:nth-child(2)").First()
-	yearOfRelease := yearOfReleaseElement.Text()
-
-	statusElement := doc.Find("div#mangaproperties table tbody tr:nth-child(4) td:nth-child(2)").First()
-	status := statusElement.Text()
-
-	authorElement := doc.Find("div#mangaproperties table tbody tr:nth-child(5) td:nth-child(2)").First()
-	author := authorElement.Text()
-
-	artistElement := doc.Find("div#mangaproperties table tbody tr:nth-child(6) td:nth-child(2)").First()
-	artist := artistElement.Text()
-
-	description := doc.Find("div#readmangasum p").First().Text()
-
 	genres := make([]DbGenre, 0)
-	doc.Find("div#mangaproperties table tbody tr:nth-child(8) td:nth-child(2) span").Each(func(i int, element *goquery.Selection) {
-		g := DbGenre{Name: element.Text()}
-		genres = append(genres, g)
-
-	})
+	for _, genre := range metadata.Genres {
+		genres = append(genres, DbGenre{Name: genre})
+	}
 
-	hostedCategoryImage, err := hostCategoryImage(c, categoryImgUrl)
+	c := app.Clients.Acquire()
+	categoryImage, err := app.hostCategoryImage(ctx, c, metadata.Image)
+	app.Clients.Release(c)
 
 	if err != nil {
 		return nil, err
@@ -654,137 +514,66 @@ func getDbCategory(in Category) (out *DbCategory, err error) {
 
 	toSave := &DbCategory{}
 
-	toSave.HostedCategoryImage = hostedCategoryImage
-	toSave.CategoryImage = categoryImgUrl.String()
-	toSave.AltName = altName
-	toSave.YearOfRelease = yearOfRelease
-	toSave.Status = status
-	toSave.Author = author
-	toSave.Artist = artist
+	toSave.HostedCategoryImage = categoryImage.URL
+	toSave.CategoryImage = metadata.Image.String()
+	toSave.AltName = metadata.AltName
+	toSave.YearOfRelease = metadata.YearOfRelease
+	toSave.Status = metadata.Status
+	toSave.Author = metadata.Author
+	toSave.Artist = metadata.Artist
 	toSave.Genres = genres
-	toSave.Description = description
+	toSave.Description = metadata.Description
 	toSave.Name = ReplaceSpecial(in.Name)
 	toSave.Link = in.Link.String()
 
-	db.Create(toSave)
+	if err := app.DB.Create(toSave).Error; err != nil {
+		return nil, err
+	}
 
-	log.Println("saved category " + toSave.Name)
+	slog.Info("saved category", "category", toSave.Name)
 
 	out = toSave
 
 	return
 }
 
-func hostCategoryImage(httpClient http.Client, source *url.URL) (out string, err error) {
-
-	uuid, err := uuid.NewV4()
-	if err != nil {
-		return "", err
-	}
-
-	hashCode := hash(uuid.String())
-	bucketNum := hashCode % 100
-	hostedSrc := fmt.Sprintf("%v/%v.jpg", bucketNum, strings.Replace(uuid.String(), "-", "", -1))
-	path := fmt.Sprintf("images/%v", hostedSrc)
-
-	file, err := os.Create(path)
-
-	if err != nil {
-		return "", err
-	}
+func (app *App) hostCategoryImage(ctx context.Context, httpClient http.Client, src *url.URL) (Blob, error) {
 
-	image, err := downloadImageWithClient(httpClient, source)
+	image, err := downloadImageWithClient(ctx, app.Clients, httpClient, src)
 
 	if err != nil {
-		return "", err
+		return Blob{}, err
 	}
 
 	buf := new(bytes.Buffer)
 	err = jpeg.Encode(buf, image, nil)
 
 	if err != nil {
-		return "", err
-	}
-
-	imgb := buf.Bytes()
-
-	_, err = file.Write(imgb)
-	file.Close()
-
-	if err != nil {
-		return "", err
+		return Blob{}, err
 	}
 
-	absHostedSrc := fmt.Sprintf("%v/%v", imageServer, path)
-
-	return absHostedSrc, nil
+	return putContent(app.Store, buf.Bytes(), "image/jpeg")
 }
 
-func chaptersFromSite(category Category) (chapters []Chapter, err error) {
-	c := acquire()
-	defer release(c)
+// existingChaptersInDb returns the links of the chapters already saved for category.
+func (app *App) existingChaptersInDb(category Category) (stringSet, error) {
+	out := newStringSet()
 
-	doc, err := newDocument(c, category.Link.String())
-	if err != nil {
-		log.Println(err)
-		return chapters, err
-	}
-
-	doc.Find("table#listing a").Each(func(i int, element *goquery.Selection) {
-		href, isExist := element.Attr("href")
-		if isExist {
-			link, err := url.Parse(root + href)
-			if err == nil {
-				chapter := Chapter{Name: ReplaceSpecial(element.Text()), Link: link}
-				chapters = append(chapters, chapter)
-			}
-		}
-	})
-
-	return
-}
-
-func getCategoriesFromSite() (categories []Category, err error) {
-	c := acquire()
-	defer release(c)
-
-	listing := root + "/alphabetical"
-
-	doc, err := newDocument(c, listing)
-
-	if err != nil {
-		log.Println(err)
-		return categories, err
-	}
-	doc.Find("ul.series_alpha li a").Each(func(i int, element *goquery.Selection) {
-		href, isExist := element.Attr("href")
-		if isExist {
-			link, err := url.Parse(root + href)
-			if err == nil {
-				cat := Category{Name: ReplaceSpecial(element.Text()), Link: link}
-
-				if err == nil {
-					categories = append(categories, cat)
-				}
-			}
-		}
-	})
-
-	log.Println(len(categories), " categories found from target site")
-
-	return categories, nil
-}
+	dbCategory := &DbCategory{}
 
-func existingChaptersInDb(category Category) (out []string, err error) {
+	if app.DB.Where(&DbCategory{Name: ReplaceSpecial(category.Name)}).First(dbCategory).RecordNotFound() {
+		return out, nil
+	}
 
-	distinctChapters := make([]DbChapter, 0)
-	db.Select("DISTINCT(Name)").Find(&distinctChapters)
+	var links []string
 
-	for _, chapter := range distinctChapters {
-		out = append(out, chapter.Name)
+	if err := app.DB.Model(&DbChapter{}).Where("db_category_id = ?", dbCategory.ID).Pluck("link", &links).Error; err != nil {
+		return nil, err
 	}
 
-	return
+	out.Add(links...)
+
+	return out, nil
 }
 
 func imageType(src *url.URL) (imageType string, err error) {
@@ -800,13 +589,28 @@ func imageType(src *url.URL) (imageType string, err error) {
 	return
 }
 
-func downloadImage(src *url.URL) (image.Image, error) {
-	client := acquire()
-	defer release(client)
-	return downloadImageWithClient(client, src)
+func (app *App) downloadImage(ctx context.Context, src *url.URL) (image.Image, error) {
+	client := app.Clients.Acquire()
+	defer app.Clients.Release(client)
+	return downloadImageWithClient(ctx, app.Clients, client, src)
+}
+
+// downloadImageWithClient downloads and decodes the image at src with
+// client, a client of clients, throttled and retried the way clients are.
+// Every attempt is bounded by the image timeout of clients as well as ctx.
+func downloadImageWithClient(ctx context.Context, clients *ClientPool, client http.Client, src *url.URL) (img image.Image, err error) {
+	err = clients.Retry.Do(ctx, "downloading "+src.String(), func() error {
+		attemptCtx, cancel := withTimeout(ctx, clients.Timeouts.Image)
+		defer cancel()
+
+		img, err = fetchImage(attemptCtx, clients.Limiter, client, src)
+		return err
+	})
+
+	return
 }
 
-func downloadImageWithClient(client http.Client, src *url.URL) (image.Image, error) {
+func fetchImage(ctx context.Context, limiter *HostLimiter, client http.Client, src *url.URL) (image.Image, error) {
 
 	imageType, err := imageType(src)
 
@@ -814,7 +618,13 @@ func downloadImageWithClient(client http.Client, src *url.URL) (image.Image, err
 		return nil, err
 	}
 
-	resp, err := client.Get(src.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", src.String(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(limiter, client, req)
 
 	if err != nil {
 		return nil, err
@@ -831,15 +641,15 @@ func downloadImageWithClient(client http.Client, src *url.URL) (image.Image, err
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, &ParseError{URL: src.String(), Err: err}
 	}
 
 	return img, nil
 }
 
-func watermark(src *url.URL) (out []byte, err error) {
+func (app *App) watermark(ctx context.Context, src *url.URL) (out []byte, err error) {
 
-	img, err := downloadImage(src)
+	img, err := app.downloadImage(ctx, src)
 
 	if err != nil {
 		return
@@ -858,6 +668,8 @@ func watermark(src *url.URL) (out []byte, err error) {
 		return
 	}
 
+	start := time.Now()
+
 	offset := image.Pt(10, 5)
 	b := img.Bounds()
 	m := image.NewRGBA(b)
@@ -865,7 +677,8 @@ func watermark(src *url.URL) (out []byte, err error) {
 	draw.Draw(m, watermark.Bounds().Add(offset), watermark, image.ZP, draw.Over)
 
 	w := new(bytes.Buffer)
-	err = jpeg.Encode(w, m, &jpeg.Options{jpeg.DefaultQuality})
+	err = jpeg.Encode(w, m, &jpeg.Options{Quality: jpeg.DefaultQuality})
+	imageEncodeSeconds.Since(start)
 
 	if err != nil {
 		return
@@ -876,44 +689,23 @@ func watermark(src *url.URL) (out []byte, err error) {
 	return
 }
 
-func hash(s string) uint32 {
-	h := fnv.New32a()
-	h.Write([]byte(s))
-	return h.Sum32()
+type stringSet map[string]struct{}
 
+func newStringSet(values ...string) stringSet {
+	s := make(stringSet, len(values))
+	s.Add(values...)
+	return s
 }
 
-func except(a []string, b []string) []string {
-	out := make([]string, 0)
-	for _, j := range a {
-
-		if !contains(b, j) {
-			out = append(out, j)
-		}
-
+func (s stringSet) Add(values ...string) {
+	for _, v := range values {
+		s[strings.TrimSpace(v)] = struct{}{}
 	}
-
-	return out
-
 }
 
-func feedsContainCategory(slice []CategoryFromFeedServer, category Category) bool {
-	for _, i := range slice {
-		if strings.TrimSpace(i.CategoryName) == strings.TrimSpace(category.Name) {
-			return true
-		}
-	}
-	return false
-}
-
-func contains(slice []string, s string) bool {
-	for _, i := range slice {
-		if strings.TrimSpace(i) == strings.TrimSpace(s) {
-			return true
-		}
-
-	}
-	return false
+func (s stringSet) Has(v string) bool {
+	_, ok := s[strings.TrimSpace(v)]
+	return ok
 }
 
 func shuffle(a []Category) {
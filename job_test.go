@@ -1,20 +1,35 @@
 package main
 
 import (
+	"net/url"
 	"testing"
 )
 
-func TestExcept(t *testing.T) {
+func TestNewChapters(t *testing.T) {
 
-	inputA := [6]string{"A", "B", "C", "D", "F", "G"}
+	var fromSite []Chapter
 
-	inputB := [3]string{"B", "C", "E"}
+	for _, name := range []string{"A", "B", "C", "D", "F", "G"} {
+		link, _ := url.Parse("http://www.mangareader.net/naruto/" + name)
+		fromSite = append(fromSite, Chapter{Name: name, Link: link})
+	}
+
+	existing := newStringSet("http://www.mangareader.net/naruto/B", "http://www.mangareader.net/naruto/C", "http://www.mangareader.net/naruto/E")
 
-	result := Except(inputA[:], inputB[:])
+	result := newChapters(fromSite, existing)
 
-	isValid := (len(result) == 4 && result[0] == "A" && result[1] == "D" && result[2] == "F" && result[3] == "G")
+	isValid := (len(result) == 4 && result[0].Name == "A" && result[1].Name == "D" && result[2].Name == "F" && result[3].Name == "G")
 
 	if !isValid {
-		t.Error()
+		t.Error(result)
+	}
+}
+
+func TestStringSet(t *testing.T) {
+
+	s := newStringSet("A ", "B")
+
+	if !s.Has("A") || !s.Has(" B") || s.Has("C") {
+		t.Error(s)
 	}
 }
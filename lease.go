@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Lease is the exclusive right of this instance to process a category,
+// stored as a DbCategoryProcessing row and kept alive by heartbeats. The
+// lease is lost when a heartbeat finds the row taken over, or when renewing
+// it fails for longer than its ttl, since another instance may then claim it.
+// A lease also holds blobGCLock shared on conn, so gc-blobs cannot sweep
+// while the category is processed.
+type Lease struct {
+	CategoryName string
+	Owner        string
+
+	db   *gorm.DB
+	conn *sql.Conn
+	ttl  time.Duration
+	stop chan struct{}
+	lost chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newInstanceID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%v-%v-%x", hostname, os.Getpid(), rand.New(rand.NewSource(time.Now().UnixNano())).Uint32())
+}
+
+// acquireLease atomically takes the lease on a category. It succeeds when
+// nobody holds the lease or the holder's lease has expired; rows left by
+// versions without leases have no expiry and are reclaimed too. It waits
+// for a running gc-blobs to finish, or for ctx to be done.
+func (app *App) acquireLease(ctx context.Context, categoryName string) (*Lease, bool, error) {
+	conn, err := lockBlobGC(ctx, app.DB, "pg_advisory_lock_shared")
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	res := app.DB.Exec(`INSERT INTO db_category_processing (category_name, owner, expires_at, created_at, updated_at)
+		VALUES (?, ?, now() + ? * interval '1 second', now(), now())
+		ON CONFLICT (category_name) DO UPDATE
+		SET owner = EXCLUDED.owner, expires_at = EXCLUDED.expires_at, created_at = now(), updated_at = now()
+		WHERE db_category_processing.expires_at IS NULL OR db_category_processing.expires_at < now()`,
+		categoryName, app.InstanceID, app.LeaseTTL.Seconds())
+
+	if res.Error != nil {
+		unlockBlobGC(conn, "pg_advisory_unlock_shared")
+		return nil, false, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		unlockBlobGC(conn, "pg_advisory_unlock_shared")
+		leaseContention.Inc()
+		return nil, false, nil
+	}
+
+	lease := &Lease{CategoryName: categoryName, Owner: app.InstanceID, db: app.DB, conn: conn, ttl: app.LeaseTTL, stop: make(chan struct{}), lost: make(chan struct{})}
+
+	lease.wg.Add(1)
+	go lease.heartbeat(lease.renew)
+
+	return lease, true, nil
+}
+
+// renew extends the lease, reporting false when the row is no longer ours.
+func (l *Lease) renew() (bool, error) {
+	res := l.db.Exec(`UPDATE db_category_processing SET expires_at = now() + ? * interval '1 second', updated_at = now()
+		WHERE category_name = ? AND owner = ?`,
+		l.ttl.Seconds(), l.CategoryName, l.Owner)
+
+	return res.RowsAffected > 0, res.Error
+}
+
+// heartbeat renews the lease every third of its ttl until it is released,
+// closing lost and stopping once it is lost.
+func (l *Lease) heartbeat(renew func() (bool, error)) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ok, err := renew()
+
+			switch {
+			case err != nil && time.Since(renewed) > l.ttl:
+				slog.Error("lost lease, unable to renew it within its ttl", "category", l.CategoryName, "err", err)
+			case err != nil:
+				slog.Warn("unable to renew lease", "category", l.CategoryName, "err", err)
+				continue
+			case !ok:
+				slog.Error("lost lease, another instance holds it", "category", l.CategoryName)
+			default:
+				renewed = time.Now()
+				continue
+			}
+
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// Context returns a context derived from parent that is also cancelled
+// when the lease is lost, so the work done under the lease stops and is
+// rolled back instead of racing the instance that took over.
+func (l *Lease) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-l.lost:
+			cancel()
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// Release stops the heartbeat and gives up the lease.
+func (l *Lease) Release() error {
+	close(l.stop)
+	l.wg.Wait()
+
+	defer unlockBlobGC(l.conn, "pg_advisory_unlock_shared")
+
+	return l.db.Exec(`DELETE FROM db_category_processing WHERE category_name = ? AND owner = ?`, l.CategoryName, l.Owner).Error
+}
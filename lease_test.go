@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testLease(ttl time.Duration, renew func() (bool, error)) *Lease {
+	l := &Lease{CategoryName: "Naruto", ttl: ttl, stop: make(chan struct{}), lost: make(chan struct{})}
+	l.wg.Add(1)
+	go l.heartbeat(renew)
+	return l
+}
+
+func TestLeaseLostToAnotherInstance(t *testing.T) {
+
+	l := testLease(30*time.Millisecond, func() (bool, error) { return false, nil })
+
+	work, cancel := l.Context(context.Background())
+	defer cancel()
+
+	select {
+	case <-work.Done():
+	case <-time.After(time.Second):
+		t.Error("work should be cancelled when the lease is taken over")
+	}
+}
+
+func TestLeaseLostWhenRenewalKeepsFailing(t *testing.T) {
+
+	l := testLease(30*time.Millisecond, func() (bool, error) { return false, errors.New("connection refused") })
+
+	select {
+	case <-l.lost:
+	case <-time.After(time.Second):
+		t.Error("lease should be lost once it could not be renewed within its ttl")
+	}
+}
+
+func TestLeaseKeptWhileRenewed(t *testing.T) {
+
+	l := testLease(30*time.Millisecond, func() (bool, error) { return true, nil })
+
+	work, cancel := l.Context(context.Background())
+	defer cancel()
+
+	time.Sleep(100 * time.Millisecond)
+
+	close(l.stop)
+	l.wg.Wait()
+
+	if work.Err() != nil {
+		t.Error(work.Err())
+	}
+}
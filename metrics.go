@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry holds the metrics exported on /metrics in the Prometheus text
+// format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var registry = &Registry{}
+
+var (
+	categoriesScanned  = registry.Counter("gomg_categories_scanned_total", "Categories whose chapters were listed.")
+	chaptersDiscovered = registry.Counter("gomg_chapters_discovered_total", "New chapters found on the source.")
+	chaptersSaved      = registry.Counter("gomg_chapters_saved_total", "Chapters saved with all their pages.")
+	chaptersFailed     = registry.Counter("gomg_chapters_failed_total", "Chapter jobs that failed, by stage.", "stage")
+	pagesFetched       = registry.Counter("gomg_pages_fetched_total", "Page images downloaded, watermarked and stored.")
+	bytesDownloaded    = registry.Counter("gomg_downloaded_bytes_total", "Bytes of html and images read from sources.")
+	httpResponses      = registry.Counter("gomg_http_responses_total", "Responses from sources by host and status code, \"error\" when there was none.", "host", "code")
+	leaseContention    = registry.Counter("gomg_lease_contention_total", "Category leases that could not be taken because another instance holds them.")
+	imageEncodeSeconds = registry.Histogram("gomg_image_encode_duration_seconds", "Time spent watermarking and encoding an image.", []float64{.01, .025, .05, .1, .25, .5, 1, 2.5})
+	clientsBenched     = registry.Counter("gomg_clients_benched_total", "Times an http client was benched after repeated failures, by identity.", "identity")
+	httpCacheResults   = registry.Counter("gomg_http_cache_total", "Listing page fetches through the http cache, by hit, miss, offline_hit or offline_miss.", "result")
+	clientWaitSeconds  = registry.Histogram("gomg_client_pool_wait_duration_seconds", "Time spent waiting for a free http client.", []float64{.001, .01, .1, .5, 1, 5, 10, 30, 60})
+)
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	c := &Counter{Name: name, Help: help, Labels: labels, values: make(map[string]float64)}
+	r.add(c)
+	return c
+}
+
+func (r *Registry) Histogram(name string, help string, buckets []float64) *Histogram {
+	h := &Histogram{Name: name, Help: help, Buckets: buckets, counts: make([]uint64, len(buckets))}
+	r.add(h)
+	return h
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Counter is a count that only goes up, one per combination of label values.
+type Counter struct {
+	Name   string
+	Help   string
+	Labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.Labels) {
+		panic(fmt.Sprintf("%v takes labels %v, got %v", c.Name, c.Labels, labelValues))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\xff")] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", c.Name, c.Help, c.Name)
+
+	if len(c.Labels) == 0 {
+		fmt.Fprintf(w, "%v %v\n", c.Name, formatFloat(c.values[""]))
+		return
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v{%v} %v\n", c.Name, formatLabels(c.Labels, strings.Split(key, "\xff")), formatFloat(c.values[key]))
+	}
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	Name    string
+	Help    string
+	Buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.Buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", h.Name, h.Help, h.Name)
+
+	for i, bound := range h.Buckets {
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", h.Name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%v_bucket{le=\"+Inf\"} %v\n", h.Name, h.count)
+	fmt.Fprintf(w, "%v_sum %v\n", h.Name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%v_count %v\n", h.Name, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%v=\"%v\"", name, labelEscaper.Replace(values[i]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// countingReader adds the bytes read through it to bytesDownloaded.
+type countingReader struct {
+	io.ReadCloser
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	bytesDownloaded.Add(float64(n))
+	return n, err
+}
+
+// serveMetrics exposes /metrics on addr in the background, for commands
+// that do not run the api.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", registry)
+
+	go func() {
+		slog.Info("serving metrics", "addr", addr)
+
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("metrics server stopped", "err", err)
+		}
+	}()
+}
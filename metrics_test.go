@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryTextFormat(t *testing.T) {
+
+	r := &Registry{}
+
+	pages := r.Counter("test_pages_total", "Pages.")
+	responses := r.Counter("test_responses_total", "Responses.", "host", "code")
+	encode := r.Histogram("test_encode_seconds", "Encode time.", []float64{0.1, 1})
+
+	pages.Add(3)
+	responses.Inc("example.com", "200")
+	responses.Inc("example.com", "200")
+	responses.Inc(`we"ird`, "error")
+	encode.Observe(0.05)
+	encode.Observe(0.5)
+	encode.Observe(5)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+
+	for _, want := range []string{
+		"# TYPE test_pages_total counter\ntest_pages_total 3\n",
+		`test_responses_total{host="example.com",code="200"} 2`,
+		`test_responses_total{host="we\"ird",code="error"} 1`,
+		"# TYPE test_encode_seconds histogram\n",
+		`test_encode_seconds_bucket{le="0.1"} 1`,
+		`test_encode_seconds_bucket{le="1"} 2`,
+		`test_encode_seconds_bucket{le="+Inf"} 3`,
+		"test_encode_seconds_sum 5.55\n",
+		"test_encode_seconds_count 3\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Error(want, "\n", body)
+		}
+	}
+}
+
+func TestCounterRejectsWrongLabels(t *testing.T) {
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	(&Registry{}).Counter("test_total", "Test.", "stage").Inc()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a versioned schema change read from migrations/NNNN_name.{up,down}.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// DbSchemaMigration records an applied migration.
+type DbSchemaMigration struct {
+	Version   int `sql:"primary_key"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, path := range names {
+		file := strings.TrimPrefix(path, "migrations/")
+
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %v must end in .up.sql or .down.sql", file)
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(file, "."+direction+".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %v must be named NNNN_name.%v.sql", file, direction)
+		}
+
+		b, err := fs.ReadFile(files, path)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%v needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]DbSchemaMigration, error) {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS db_schema_migration (
+		version integer PRIMARY KEY,
+		name varchar(255),
+		applied_at timestamp with time zone
+	)`).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []DbSchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]DbSchemaMigration)
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+// runMigrate implements `gomg migrate up|down|status`.
+func runMigrate(db *gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: gomg migrate up|down|status")
+	}
+
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		return migrateUp(db, migrations, applied)
+	case "down":
+		return migrateDown(db, migrations, applied)
+	case "status":
+		for _, m := range migrations {
+			status := "pending"
+			if row, ok := applied[m.Version]; ok {
+				status = "applied " + row.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%v\t%v\n", m.Version, m.Name, status)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+}
+
+// migrateUp applies every pending migration in order, each in its own transaction.
+func migrateUp(db *gorm.DB, migrations []Migration, applied map[int]DbSchemaMigration) error {
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		slog.Info("applying migration", "version", m.Version, "name", m.Name)
+
+		tx := db.Begin()
+
+		if err := tx.Exec(m.Up).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %04d_%v: %v", m.Version, m.Name, err)
+		}
+
+		if err := tx.Create(&DbSchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateDown reverts the most recently applied migration.
+func migrateDown(db *gorm.DB, migrations []Migration, applied map[int]DbSchemaMigration) error {
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		slog.Info("reverting migration", "version", m.Version, "name", m.Name)
+
+		tx := db.Begin()
+
+		if err := tx.Exec(m.Down).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %04d_%v: %v", m.Version, m.Name, err)
+		}
+
+		if err := tx.Where("version = ?", m.Version).Delete(&DbSchemaMigration{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		return tx.Commit().Error
+	}
+
+	slog.Info("no migration to revert")
+
+	return nil
+}
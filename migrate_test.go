@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+
+	migrations, err := loadMigrations(migrationFiles)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) < 2 {
+		t.Fatal(migrations)
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Error("migrations should be numbered from 1 without gaps", m.Version, m.Name)
+		}
+
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Error("empty migration", m.Version, m.Name)
+		}
+	}
+
+	if migrations[0].Name != "create_tables" || !strings.Contains(migrations[0].Up, "CREATE TABLE IF NOT EXISTS db_page") {
+		t.Error(migrations[0].Name)
+	}
+}
+
+func TestLoadMigrationsRejectsMissingDown(t *testing.T) {
+
+	files := fstest.MapFS{
+		"migrations/0001_create.up.sql": {Data: []byte("CREATE TABLE a ();")},
+	}
+
+	if _, err := loadMigrations(files); err == nil {
+		t.Error("expected error for migration without down file")
+	}
+
+	files = fstest.MapFS{
+		"migrations/create.up.sql": {Data: []byte("CREATE TABLE a ();")},
+	}
+
+	if _, err := loadMigrations(files); err == nil {
+		t.Error("expected error for migration without version")
+	}
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS db_category_processing;
+DROP TABLE IF EXISTS db_hit;
+DROP TABLE IF EXISTS db_page;
+DROP TABLE IF EXISTS db_chapter;
+DROP TABLE IF EXISTS db_genre;
+DROP TABLE IF EXISTS db_category;
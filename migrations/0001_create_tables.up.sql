@@ -0,0 +1,79 @@
+CREATE TABLE IF NOT EXISTS db_category (
+    id serial PRIMARY KEY,
+    name varchar(512),
+    category_image varchar(512),
+    hosted_category_image varchar(10120),
+    alt_name varchar(512),
+    year_of_release varchar(512),
+    status varchar(512),
+    author varchar(512),
+    artist varchar(512),
+    description varchar(10120),
+    link varchar(512),
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS db_genre (
+    id serial PRIMARY KEY,
+    name varchar(255),
+    db_category_id integer,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS db_chapter (
+    id serial PRIMARY KEY,
+    name varchar(10120),
+    link varchar(512),
+    chapter_no integer,
+    total_pages integer,
+    scrapped_time bigint,
+    db_category_id integer,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS db_page (
+    id serial PRIMARY KEY,
+    manga_src varchar(10512),
+    hosted_manga_src varchar(10512),
+    page_no integer,
+    db_chapter_id integer,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS db_hit (
+    id serial PRIMARY KEY,
+    count integer,
+    chapter_name varchar(10512)
+);
+
+CREATE TABLE IF NOT EXISTS db_category_processing (
+    id serial PRIMARY KEY,
+    category_name varchar(10512),
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+DO $$ BEGIN
+    ALTER TABLE db_genre ADD CONSTRAINT fk_db_genre_db_category FOREIGN KEY (db_category_id) REFERENCES db_category (id) ON DELETE CASCADE;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+DO $$ BEGIN
+    ALTER TABLE db_chapter ADD CONSTRAINT fk_db_chapter_db_category FOREIGN KEY (db_category_id) REFERENCES db_category (id) ON DELETE CASCADE;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+DO $$ BEGIN
+    ALTER TABLE db_page ADD CONSTRAINT fk_db_page_db_chapter FOREIGN KEY (db_chapter_id) REFERENCES db_chapter (id) ON DELETE CASCADE;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_db_category_name ON db_category (name);
+CREATE INDEX IF NOT EXISTS idx_db_genre_db_category_id ON db_genre (db_category_id);
+CREATE INDEX IF NOT EXISTS idx_db_chapter_category_link ON db_chapter (db_category_id, link);
+CREATE INDEX IF NOT EXISTS idx_db_chapter_link ON db_chapter (link);
+CREATE INDEX IF NOT EXISTS idx_db_page_db_chapter_id ON db_page (db_chapter_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS uix_db_category_processing_category_name;
+
+ALTER TABLE db_category_processing DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE db_category_processing DROP COLUMN IF EXISTS owner;
+
+DROP TABLE IF EXISTS db_failed_job;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS db_failed_job (
+    id serial PRIMARY KEY,
+    category_name varchar(512),
+    category_link varchar(512),
+    chapter_name varchar(10120),
+    chapter_link varchar(512),
+    stage varchar(64),
+    error varchar(10120),
+    attempts integer,
+    dead boolean,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uix_db_failed_job_chapter_link ON db_failed_job (chapter_link);
+CREATE INDEX IF NOT EXISTS idx_db_failed_job_category_name ON db_failed_job (category_name, dead);
+
+ALTER TABLE db_category_processing ADD COLUMN IF NOT EXISTS owner varchar(512);
+ALTER TABLE db_category_processing ADD COLUMN IF NOT EXISTS expires_at timestamp with time zone;
+
+-- leftover locks from before leases would block the unique index
+DELETE FROM db_category_processing a USING db_category_processing b
+WHERE a.category_name = b.category_name AND a.id < b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uix_db_category_processing_category_name ON db_category_processing (category_name);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_db_hit_day;
+DROP INDEX IF EXISTS uix_db_hit_chapter_day;
+
+DELETE FROM db_hit;
+
+ALTER TABLE db_hit ALTER COLUMN count DROP NOT NULL;
+ALTER TABLE db_hit ALTER COLUMN count DROP DEFAULT;
+ALTER TABLE db_hit DROP COLUMN IF EXISTS day;
+ALTER TABLE db_hit DROP COLUMN IF EXISTS db_chapter_id;
+ALTER TABLE db_hit ADD COLUMN IF NOT EXISTS chapter_name varchar(10512);
@@ -0,0 +1,11 @@
+-- hits were never written when they were keyed on chapter_name, so nothing is lost
+DELETE FROM db_hit;
+
+ALTER TABLE db_hit DROP COLUMN IF EXISTS chapter_name;
+ALTER TABLE db_hit ADD COLUMN IF NOT EXISTS db_chapter_id integer NOT NULL REFERENCES db_chapter (id) ON DELETE CASCADE;
+ALTER TABLE db_hit ADD COLUMN IF NOT EXISTS day date NOT NULL;
+ALTER TABLE db_hit ALTER COLUMN count SET DEFAULT 0;
+ALTER TABLE db_hit ALTER COLUMN count SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uix_db_hit_chapter_day ON db_hit (db_chapter_id, day);
+CREATE INDEX IF NOT EXISTS idx_db_hit_day ON db_hit (day);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uix_db_chapter_category_link;
+CREATE INDEX IF NOT EXISTS idx_db_chapter_category_link ON db_chapter (db_category_id, link);
@@ -0,0 +1,6 @@
+-- chapters saved twice by instances that raced on a lost lease would block the unique index
+DELETE FROM db_chapter a USING db_chapter b
+WHERE a.db_category_id = b.db_category_id AND a.link = b.link AND a.id > b.id;
+
+DROP INDEX IF EXISTS idx_db_chapter_category_link;
+CREATE UNIQUE INDEX IF NOT EXISTS uix_db_chapter_category_link ON db_chapter (db_category_id, link);
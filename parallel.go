@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+)
+
+// parallel calls fn for every index in [0, n) from at most workers goroutines
+// at a time. Once a call fails no new calls are started; parallel waits for
+// the running ones and returns the first error.
+func parallel(n int, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	sem := make(chan struct{}, workers)
+
+	for i := 0; i < n && !failed(); i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if failed() {
+				return
+			}
+
+			if err := fn(i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+
+	var running, maxRunning int32
+	results := make([]int, 20)
+
+	err := parallel(len(results), 4, func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+		results[i] = i * i
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if maxRunning > 4 {
+		t.Error("more than 4 workers ran at once:", maxRunning)
+	}
+
+	for i, result := range results {
+		if result != i*i {
+			t.Error(i, result)
+		}
+	}
+}
+
+func TestParallelStopsOnError(t *testing.T) {
+
+	var calls int32
+
+	err := parallel(100, 1, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		if i == 2 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	if err == nil || err.Error() != "failed" {
+		t.Error(err)
+	}
+
+	if calls != 3 {
+		t.Error(calls)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PopularityProvider ranks categories for -runMode=top. Popular returns at
+// most n category names, most popular first.
+type PopularityProvider interface {
+	Popular(ctx context.Context, n int) ([]string, error)
+}
+
+type CategoryFromFeedServer struct {
+	CategoryName string `json:"manga_name"`
+}
+
+// HitsPopularity ranks categories by the chapter views recorded in db_hit.
+type HitsPopularity struct {
+	App  *App
+	Days int
+}
+
+func (p *HitsPopularity) Popular(ctx context.Context, n int) ([]string, error) {
+	popular, err := p.App.popularCategories(p.Days, n, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(popular))
+	for _, c := range popular {
+		names = append(names, c.Name)
+	}
+
+	return names, nil
+}
+
+// FilePopularity reads the ranking from a json file, either a list of names
+// or the [{"manga_name": ...}] format of the popular feed.
+type FilePopularity struct {
+	Path string
+}
+
+func (p *FilePopularity) Popular(ctx context.Context, n int) ([]string, error) {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := parsePopularNames(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %v: %v", p.Path, err)
+	}
+
+	return firstN(names, n), nil
+}
+
+// FeedPopularity fetches the ranking from an http feed serving
+// [{"manga_name": ...}], throttled by Limiter unless it is nil.
+type FeedPopularity struct {
+	URL     string
+	Client  *http.Client
+	Limiter *HostLimiter
+}
+
+func (p *FeedPopularity) Popular(ctx context.Context, n int) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := doRequest(p.Limiter, *p.Client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, &StatusError{URL: p.URL, StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := parsePopularNames(b)
+	if err != nil {
+		return nil, &ParseError{URL: p.URL, Err: err}
+	}
+
+	return firstN(names, n), nil
+}
+
+func parsePopularNames(b []byte) ([]string, error) {
+	var names []string
+	if err := json.Unmarshal(b, &names); err == nil {
+		return names, nil
+	}
+
+	var feed []CategoryFromFeedServer
+	if err := json.Unmarshal(b, &feed); err != nil {
+		return nil, err
+	}
+
+	names = make([]string, 0, len(feed))
+	for _, f := range feed {
+		names = append(names, f.CategoryName)
+	}
+
+	return names, nil
+}
+
+func firstN(names []string, n int) []string {
+	if len(names) > n {
+		return names[:n]
+	}
+	return names
+}
+
+func newPopularityProvider(kind string, app *App, file string, feedURL string) (PopularityProvider, error) {
+	switch kind {
+	case "hits":
+		return &HitsPopularity{App: app, Days: defaultPopularDays}, nil
+	case "file":
+		return &FilePopularity{Path: file}, nil
+	case "feed":
+		return &FeedPopularity{URL: feedURL, Client: &http.Client{Timeout: time.Minute}, Limiter: app.Clients.Limiter}, nil
+	}
+
+	return nil, fmt.Errorf("unknown popularity provider %q, expected 'hits', 'file' or 'feed'", kind)
+}
+
+// normalizedName is the name categories are matched on across sources.
+func normalizedName(name string) string {
+	return strings.ToLower(ReplaceSpecial(name))
+}
+
+// filterToTop keeps the n most popular categories, in the order of
+// categories. When the provider fails or has not ranked anything yet, the
+// last ranking it returned is used instead. Without any ranking, such as
+// hits on a fresh database, n random categories are kept so the crawl still
+// makes progress.
+func (app *App) filterToTop(ctx context.Context, categories []Category, n int) ([]Category, error) {
+	slog.Info("keeping the most popular categories", "topN", n)
+
+	names, err := app.Popularity.Popular(ctx, n)
+
+	if err == nil && len(names) == 0 {
+		err = errors.New("no category has been ranked yet")
+	}
+
+	if err != nil {
+		if app.lastPopular == nil {
+			slog.Warn("ranking categories failed and there is no previous ranking, keeping random categories", "topN", n, "err", err)
+			return sampleCategories(categories, n), nil
+		}
+
+		slog.Warn("ranking categories failed, using the previous ranking", "categories", len(app.lastPopular), "err", err)
+		names = app.lastPopular
+	} else {
+		app.lastPopular = names
+	}
+
+	return filterByNames(categories, names), nil
+}
+
+// sampleCategories keeps n random categories, in the order of categories.
+func sampleCategories(categories []Category, n int) (result []Category) {
+	perm := rand.Perm(len(categories))
+	if len(perm) > n {
+		perm = perm[:n]
+	}
+
+	picked := make(map[int]bool, n)
+	for _, i := range perm {
+		picked[i] = true
+	}
+
+	for i, cat := range categories {
+		if picked[i] {
+			result = append(result, cat)
+		}
+	}
+
+	return
+}
+
+func filterByNames(categories []Category, names []string) (result []Category) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[normalizedName(name)] = true
+	}
+
+	for _, cat := range categories {
+		if wanted[normalizedName(cat.Name)] {
+			result = append(result, cat)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakePopularity struct {
+	names []string
+	err   error
+}
+
+func (p *fakePopularity) Popular(ctx context.Context, n int) ([]string, error) {
+	return firstN(p.names, n), p.err
+}
+
+func testCategories(names ...string) (categories []Category) {
+	for _, name := range names {
+		link, _ := url.Parse("http://www.mangareader.net/" + name)
+		categories = append(categories, Category{Name: name, Link: link})
+	}
+	return
+}
+
+func categoryNames(categories []Category) (names []string) {
+	for _, c := range categories {
+		names = append(names, c.Name)
+	}
+	return
+}
+
+func TestFilterByNamesNormalizes(t *testing.T) {
+
+	categories := testCategories("Naruto", "One Piece", "Fairy Tail", "Bleach")
+
+	result := filterByNames(categories, []string{"bleach", "one piece!", "  naruto "})
+
+	if names := categoryNames(result); !reflect.DeepEqual(names, []string{"Naruto", "One Piece", "Bleach"}) {
+		t.Error(names)
+	}
+}
+
+func TestFilterToTopReusesLastRanking(t *testing.T) {
+
+	provider := &fakePopularity{names: []string{"Naruto", "Bleach"}}
+	app := &App{Popularity: provider}
+	categories := testCategories("Naruto", "One Piece", "Bleach")
+
+	if result, err := (&App{Popularity: &fakePopularity{err: errors.New("feed down")}}).filterToTop(context.Background(), categories, 2); err != nil || len(result) != 2 {
+		t.Error("expected random categories without any previous ranking", result, err)
+	}
+
+	result, err := app.filterToTop(context.Background(), categories, 1)
+
+	if names := categoryNames(result); err != nil || !reflect.DeepEqual(names, []string{"Naruto"}) {
+		t.Error(names, err)
+	}
+
+	provider.err = errors.New("feed down")
+
+	result, err = app.filterToTop(context.Background(), categories, 1)
+
+	if names := categoryNames(result); err != nil || !reflect.DeepEqual(names, []string{"Naruto"}) {
+		t.Error(names, err)
+	}
+}
+
+func TestFilterToTopEmptyRanking(t *testing.T) {
+
+	provider := &fakePopularity{}
+	app := &App{Popularity: provider}
+	categories := testCategories("Naruto", "One Piece", "Bleach")
+
+	// a fresh database has no hits yet, something must still be crawled
+	if result, err := app.filterToTop(context.Background(), categories, 2); err != nil || len(result) != 2 || app.lastPopular != nil {
+		t.Error(result, err)
+	}
+
+	app.lastPopular = []string{"Bleach"}
+
+	result, err := app.filterToTop(context.Background(), categories, 1)
+
+	if names := categoryNames(result); err != nil || !reflect.DeepEqual(names, []string{"Bleach"}) {
+		t.Error(names, err)
+	}
+}
+
+func TestFilePopularity(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := filepath.Join(dir, "names.json")
+	ioutil.WriteFile(names, []byte(`["Naruto", "Bleach", "One Piece"]`), 0644)
+
+	result, err := (&FilePopularity{Path: names}).Popular(context.Background(), 2)
+
+	if err != nil || !reflect.DeepEqual(result, []string{"Naruto", "Bleach"}) {
+		t.Error(result, err)
+	}
+
+	feed := filepath.Join(dir, "feed.json")
+	ioutil.WriteFile(feed, []byte(`[{"manga_name": "Bleach"}]`), 0644)
+
+	result, err = (&FilePopularity{Path: feed}).Popular(context.Background(), 2)
+
+	if err != nil || !reflect.DeepEqual(result, []string{"Bleach"}) {
+		t.Error(result, err)
+	}
+}
+
+func TestFeedPopularity(t *testing.T) {
+
+	status := http.StatusOK
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, `[{"manga_name": "Naruto"}, {"manga_name": "Bleach"}]`)
+	}))
+	defer server.Close()
+
+	provider := &FeedPopularity{URL: server.URL, Client: &http.Client{Timeout: time.Minute}}
+
+	result, err := provider.Popular(context.Background(), 30)
+
+	if err != nil || !reflect.DeepEqual(result, []string{"Naruto", "Bleach"}) {
+		t.Error(result, err)
+	}
+
+	status = http.StatusNotFound
+
+	if _, err := provider.Popular(context.Background(), 30); err == nil {
+		t.Error("expected an error for a 404 feed")
+	}
+}
@@ -1,17 +1,12 @@
 #!/bin/bash
 
-mkdir images
-
-sudo sshfs root@xxx.xxx.xxx.xxx/mnt/images ./images -o nonempty
-
-export POSTGRES_USER=xxx
+# secrets stay out of the config file
 export POSTGRES_PASSWORD=xxx
-export POSTGRES_DB=xxx
-export POSTGRES_PORT_5432_TCP_ADDR=xxx
-export POSTGRES_PORT_5432_TCP_PORT=xxx
-export IMAGE_SERVER=http://xxx.xxx.xxx
-./createFolder.py
+export S3_ACCESS_KEY=xxx
+export S3_SECRET_KEY=xxx
 
 go get .
 
 go build . 
+
+./gomg -config configs/prod.json
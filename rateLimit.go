@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HostLimiter throttles outbound requests with a token bucket per host and
+// pauses a host entirely when it answers 429 or 503.
+// A zero RPS disables the token bucket but still honors pauses.
+type HostLimiter struct {
+	RPS    float64
+	Burst  int
+	Jitter time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// defaultBackoff is how long a host is paused after a 429 or 503 without a
+// usable Retry-After header.
+const defaultBackoff = 30 * time.Second
+
+// Wait blocks until a request to host is allowed or ctx is done.
+func (l *HostLimiter) Wait(ctx context.Context, host string) error {
+	delay := l.reserve(host, time.Now())
+
+	if l.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(l.Jitter)))
+	}
+
+	if delay > 0 && !sleep(ctx, delay) {
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// Pause stops requests to host for d.
+func (l *HostLimiter) Pause(host string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(host, time.Now())
+	until := time.Now().Add(d)
+	if until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+// reserve takes a token for host and returns how long the caller has to
+// wait before using it.
+func (l *HostLimiter) reserve(host string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(host, now)
+
+	var delay time.Duration
+
+	if l.RPS > 0 {
+		burst := float64(l.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+
+		b.tokens += now.Sub(b.last).Seconds() * l.RPS
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+
+		b.tokens--
+		if b.tokens < 0 {
+			delay = time.Duration(-b.tokens / l.RPS * float64(time.Second))
+		}
+	}
+
+	if b.blockedUntil.After(now.Add(delay)) {
+		delay = b.blockedUntil.Sub(now)
+	}
+
+	return delay
+}
+
+func (l *HostLimiter) bucket(host string, now time.Time) *tokenBucket {
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[host] = b
+	}
+
+	return b
+}
+
+// doRequest sends req through limiter, unless it is nil, giving up waiting
+// when the request's context is done. A response that is not a success is returned as
+// the error statusError gives it; a 429 or 503 also pauses the host for its
+// Retry-After.
+func doRequest(limiter *HostLimiter, c http.Client, req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	if limiter != nil {
+		if err := limiter.Wait(req.Context(), host); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		httpResponses.Inc(host, "error")
+		return nil, err
+	}
+
+	httpResponses.Inc(host, strconv.Itoa(res.StatusCode))
+	res.Body = countingReader{res.Body}
+
+	err = statusError(req.URL.String(), res.StatusCode, res.Status)
+	if err == nil {
+		return res, nil
+	}
+
+	res.Body.Close()
+
+	if limited, ok := err.(*RateLimitedError); ok && limiter != nil {
+		backoff, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			backoff = defaultBackoff
+		}
+
+		slog.Warn("host is throttling, pausing it", "host", host, "status", res.StatusCode, "pause", backoff)
+
+		limiter.Pause(host, backoff)
+		limited.RetryAfter = backoff
+	}
+
+	return nil, err
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an http date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if t.Before(now) {
+			return 0, true
+		}
+		return t.Sub(now), true
+	}
+
+	return 0, false
+}
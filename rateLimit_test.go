@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterReserve(t *testing.T) {
+
+	l := &HostLimiter{RPS: 1, Burst: 2}
+	now := time.Now()
+
+	for i, expected := range []time.Duration{0, 0, time.Second, 2 * time.Second} {
+		if delay := l.reserve("a.com", now); delay != expected {
+			t.Error(i, delay)
+		}
+	}
+
+	if delay := l.reserve("b.com", now); delay != 0 {
+		t.Error("hosts should not share a bucket", delay)
+	}
+
+	if delay := l.reserve("b.com", now.Add(10*time.Second)); delay != 0 {
+		t.Error("bucket should refill", delay)
+	}
+}
+
+func TestHostLimiterPause(t *testing.T) {
+
+	l := &HostLimiter{}
+
+	l.Pause("a.com", time.Minute)
+
+	if delay := l.reserve("a.com", time.Now()); delay < 59*time.Second {
+		t.Error(delay)
+	}
+
+	if delay := l.reserve("b.com", time.Now()); delay != 0 {
+		t.Error(delay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+
+	now := time.Date(2017, 8, 8, 0, 0, 0, 0, time.UTC)
+
+	if d, ok := parseRetryAfter("120", now); !ok || d != 2*time.Minute {
+		t.Error(d, ok)
+	}
+
+	if d, ok := parseRetryAfter("Tue, 08 Aug 2017 00:00:30 GMT", now); !ok || d != 30*time.Second {
+		t.Error(d, ok)
+	}
+
+	if _, ok := parseRetryAfter("soon", now); ok {
+		t.Error("expected invalid header to be rejected")
+	}
+}
+
+func TestDoRequestPausesHostOnTooManyRequests(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+
+	defer server.Close()
+
+	limiter := &HostLimiter{}
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+
+	_, err := doRequest(limiter, http.Client{}, req)
+
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) || limited.RetryAfter != time.Minute {
+		t.Error(err)
+	}
+
+	u, _ := url.Parse(server.URL)
+
+	if delay := limiter.reserve(u.Host, time.Now()); delay < 59*time.Second {
+		t.Error(delay)
+	}
+}
+
+func TestHostLimiterWaitGivesUpWhenCancelled(t *testing.T) {
+
+	l := &HostLimiter{}
+	l.Pause("a.com", time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.Wait(ctx, "a.com"); err != context.Canceled {
+		t.Error(err)
+	}
+
+	if err := l.Wait(ctx, "b.com"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries transient fetch failures with exponential backoff and
+// full jitter: attempt n waits a random duration up to BaseDelay*2^n, capped
+// at MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// RetryError is the final failure of an operation that ran out of attempts
+// or hit an error that is not worth retrying.
+type RetryError struct {
+	Op       string
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v failed after %v attempt(s): %v", e.Op, e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Do calls fn until it succeeds, returns an error isRetryable rejects,
+// MaxAttempts is reached or ctx is done.
+func (p RetryPolicy) Do(ctx context.Context, op string, fn func() error) error {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = fn()
+
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil || !isRetryable(err) || attempt == attempts {
+			return &RetryError{Op: op, Attempts: attempt, Err: err}
+		}
+
+		delay := p.delay(attempt)
+
+		loggerFrom(ctx).Warn("retrying", "op", op, "attempt", attempt, "delay", delay, "err", err)
+
+		if !sleep(ctx, delay) {
+			return &RetryError{Op: op, Attempts: attempt, Err: ctx.Err()}
+		}
+	}
+
+	return &RetryError{Op: op, Attempts: attempts, Err: err}
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	max := p.BaseDelay << uint(attempt-1)
+	if max <= 0 || (p.MaxDelay > 0 && max > p.MaxDelay) {
+		max = p.MaxDelay
+	}
+
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// isRetryable reports whether err is a transient failure: timeouts,
+// connection resets, truncated bodies, rate limiting and server errors. A
+// cancelled context, a NotFoundError or a ParseError of a complete response
+// is not.
+func isRetryable(err error) bool {
+	var limited *RateLimitedError
+	var serverErr *ServerError
+	if errors.As(err, &limited) || errors.As(err, &serverErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	calls := 0
+	err := policy.Do(context.Background(), "fetch", func() error {
+		calls++
+		if calls < 3 {
+			return &ServerError{StatusCode: 503, Status: "503 Service Unavailable"}
+		}
+		return nil
+	})
+
+	if err != nil || calls != 3 {
+		t.Error(calls, err)
+	}
+
+	calls = 0
+	err = policy.Do(context.Background(), "fetch", func() error {
+		calls++
+		return errors.New("cannot find img src on page")
+	})
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 || calls != 1 {
+		t.Error("non retryable errors should fail at once", calls, err)
+	}
+
+	calls = 0
+	err = policy.Do(context.Background(), "fetch", func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 3 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error(calls, err)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if delay := policy.delay(attempt); delay < 0 || delay > max {
+				t.Error(attempt, delay)
+			}
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+
+	retryable := []error{
+		&RateLimitedError{StatusCode: 429},
+		&ServerError{StatusCode: 502},
+		&ParseError{Err: io.ErrUnexpectedEOF},
+		fmt.Errorf("reading body: %w", syscall.ECONNRESET),
+		io.ErrUnexpectedEOF,
+	}
+
+	for _, err := range retryable {
+		if !isRetryable(err) {
+			t.Error(err)
+		}
+	}
+
+	notRetryable := []error{
+		&StatusError{StatusCode: 404},
+		&NotFoundError{},
+		&ParseError{Err: errors.New("cannot find img src")},
+		context.Canceled,
+		errors.New("only png and jpg is supported"),
+	}
+
+	for _, err := range notRetryable {
+		if isRetryable(err) {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRetryPolicyStopsWhenCancelled(t *testing.T) {
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	calls := 0
+	err := policy.Do(ctx, "fetch", func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+
+	if calls != 1 || !errors.Is(err, context.Canceled) {
+		t.Error(calls, err)
+	}
+}
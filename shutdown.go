@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// workContext returns the context in-flight chapters run under. It outlives
+// ctx by app.ShutdownTimeout, so on shutdown running chapters get that long
+// to finish before they are cancelled and rolled back.
+func (app *App) workContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	go func() {
+		select {
+		case <-work.Done():
+		case <-ctx.Done():
+			sleep(work, app.ShutdownTimeout)
+			cancel()
+		}
+	}()
+
+	return work, cancel
+}
+
+// sleep waits for d or until ctx is done, reporting whether it waited the
+// whole time.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkContextOutlivesShutdownByTimeout(t *testing.T) {
+
+	app := &App{ShutdownTimeout: 50 * time.Millisecond}
+
+	ctx, stop := context.WithCancel(context.Background())
+
+	work, cancel := app.workContext(ctx)
+	defer cancel()
+
+	stop()
+
+	if !sleep(work, 10*time.Millisecond) {
+		t.Error("work was cancelled as soon as shutdown started")
+	}
+
+	select {
+	case <-work.Done():
+	case <-time.After(time.Second):
+		t.Error("work was not cancelled after the shutdown timeout")
+	}
+}
+
+func TestWorkContextCancel(t *testing.T) {
+
+	app := &App{ShutdownTimeout: time.Hour}
+
+	work, cancel := app.workContext(context.Background())
+	cancel()
+
+	if work.Err() == nil {
+		t.Error("expected work to be cancelled")
+	}
+}
+
+func TestSleep(t *testing.T) {
+
+	if !sleep(context.Background(), time.Millisecond) {
+		t.Error("expected a full sleep")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if sleep(ctx, time.Hour) {
+		t.Error("expected sleep to stop when ctx is done")
+	}
+}
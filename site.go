@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SiteDefinition declares where a site lists its categories and which
+// selectors pick out categories, chapters, pages, images and metadata.
+// It is loaded from a JSON file so selector fixes don't need a recompile.
+type SiteDefinition struct {
+	Name         string       `json:"name"`
+	Root         string       `json:"root"`
+	Listing      string       `json:"listing"`
+	NotFoundHTML string       `json:"notFoundHtml"`
+	Categories   Selector     `json:"categories"`
+	Chapters     Selector     `json:"chapters"`
+	Pages        Selector     `json:"pages"`
+	Image        Selector     `json:"image"`
+	Metadata     SiteMetadata `json:"metadata"`
+}
+
+type SiteMetadata struct {
+	Image         Selector `json:"image"`
+	AltName       Selector `json:"altName"`
+	YearOfRelease Selector `json:"yearOfRelease"`
+	Status        Selector `json:"status"`
+	Author        Selector `json:"author"`
+	Artist        Selector `json:"artist"`
+	Description   Selector `json:"description"`
+	Genres        Selector `json:"genres"`
+}
+
+// Selector is a CSS selector plus the attribute to read from the matched
+// elements. An empty Attr reads the element text instead.
+type Selector struct {
+	Selector string `json:"selector"`
+	Attr     string `json:"attr,omitempty"`
+}
+
+var mangaReaderSite = SiteDefinition{
+	Name:         "mangareader",
+	Root:         "http://www.mangareader.net",
+	Listing:      "/alphabetical",
+	NotFoundHTML: "<html><head></head><body><h1>404 Not Found</h1></body></html>",
+	Categories:   Selector{Selector: "ul.series_alpha li a", Attr: "href"},
+	Chapters:     Selector{Selector: "table#listing a", Attr: "href"},
+	Pages:        Selector{Selector: "select option", Attr: "value"},
+	Image:        Selector{Selector: "div#imgholder img#img", Attr: "src"},
+	Metadata: SiteMetadata{
+		Image:         Selector{Selector: "div#mangaimg img", Attr: "src"},
+		AltName:       Selector{Selector: "div#mangaproperties table tbody tr:nth-child(2) td:nth-child(2)"},
+		YearOfRelease: Selector{Selector: "div#mangaproperties table tbody tr:nth-child(3) td:nth-child(2)"},
+		Status:        Selector{Selector: "div#mangaproperties table tbody tr:nth-child(4) td:nth-child(2)"},
+		Author:        Selector{Selector: "div#mangaproperties table tbody tr:nth-child(5) td:nth-child(2)"},
+		Artist:        Selector{Selector: "div#mangaproperties table tbody tr:nth-child(6) td:nth-child(2)"},
+		Description:   Selector{Selector: "div#readmangasum p"},
+		Genres:        Selector{Selector: "div#mangaproperties table tbody tr:nth-child(8) td:nth-child(2) span"},
+	},
+}
+
+func loadSiteDefinition(path string) (*SiteDefinition, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	site := &SiteDefinition{}
+	if err := json.Unmarshal(b, site); err != nil {
+		return nil, fmt.Errorf("parsing site definition %v: %v", path, err)
+	}
+
+	if err := site.validate(); err != nil {
+		return nil, fmt.Errorf("invalid site definition %v: %v", path, err)
+	}
+
+	return site, nil
+}
+
+func (site *SiteDefinition) validate() error {
+	if site.Name == "" {
+		return errors.New("name is required")
+	}
+
+	root, err := url.Parse(site.Root)
+	if err != nil || !root.IsAbs() {
+		return fmt.Errorf("root %q must be an absolute url", site.Root)
+	}
+
+	required := map[string]Selector{
+		"categories":     site.Categories,
+		"chapters":       site.Chapters,
+		"pages":          site.Pages,
+		"image":          site.Image,
+		"metadata.image": site.Metadata.Image,
+	}
+
+	for name, selector := range required {
+		if selector.Selector == "" {
+			return fmt.Errorf("%v.selector is required", name)
+		}
+		if selector.Attr == "" {
+			return fmt.Errorf("%v.attr is required", name)
+		}
+	}
+
+	return nil
+}
+
+func (site *SiteDefinition) resolve(ref string) (*url.URL, error) {
+	root, err := url.Parse(site.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return nil, err
+	}
+
+	return root.ResolveReference(u), nil
+}
+
+// Each calls fn with the text and the attribute (or text) of every element matching s.
+func (s Selector) Each(doc *goquery.Document, fn func(text string, value string)) {
+	doc.Find(s.Selector).Each(func(i int, element *goquery.Selection) {
+		if value, ok := s.value(element); ok {
+			fn(element.Text(), value)
+		}
+	})
+}
+
+// All returns the attribute (or text) of every element matching s.
+func (s Selector) All(doc *goquery.Document) (out []string) {
+	s.Each(doc, func(text string, value string) {
+		out = append(out, value)
+	})
+	return
+}
+
+// First returns the attribute (or text) of the first element matching s.
+func (s Selector) First(doc *goquery.Document) (string, bool) {
+	if s.Selector == "" {
+		return "", false
+	}
+
+	element := doc.Find(s.Selector).First()
+	if element.Length() == 0 {
+		return "", false
+	}
+
+	return s.value(element)
+}
+
+func (s Selector) value(element *goquery.Selection) (string, bool) {
+	if s.Attr == "" {
+		return element.Text(), true
+	}
+	return element.Attr(s.Attr)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SiteSource scrapes any site described by a SiteDefinition.
+type SiteSource struct {
+	Site    *SiteDefinition
+	Clients *ClientPool
+	// Cache, if not nil, keeps the category listing and category pages.
+	Cache *HTTPCache
+}
+
+// document fetches the page at link, each attempt bounded by timeout,
+// through cache unless it is nil. The site's not found page is returned as a
+// NotFoundError.
+func (s *SiteSource) document(ctx context.Context, link string, timeout time.Duration, cache *HTTPCache) (doc *goquery.Document, err error) {
+	c := s.Clients.Acquire()
+	defer s.Clients.Release(c)
+
+	doc, err = newDocument(ctx, s.Clients, c, link, timeout, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Site.NotFoundHTML != "" {
+		docHtml, err := doc.Html()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.TrimSpace(docHtml) == s.Site.NotFoundHTML {
+			return nil, &NotFoundError{URL: link}
+		}
+	}
+
+	return doc, nil
+}
+
+func (s *SiteSource) Categories(ctx context.Context) (categories []Category, err error) {
+	listing, err := s.Site.resolve(s.Site.Listing)
+	if err != nil {
+		return categories, err
+	}
+
+	doc, err := s.document(ctx, listing.String(), s.Clients.Timeouts.Listing, s.Cache)
+	if err != nil {
+		return categories, err
+	}
+
+	s.Site.Categories.Each(doc, func(text string, href string) {
+		link, err := s.Site.resolve(href)
+		if err == nil {
+			categories = append(categories, Category{Name: ReplaceSpecial(text), Link: link})
+		}
+	})
+
+	slog.Info("listed categories", "source", s.Site.Name, "count", len(categories))
+
+	return categories, nil
+}
+
+func (s *SiteSource) Chapters(ctx context.Context, category Category) (chapters []Chapter, err error) {
+	doc, err := s.document(ctx, category.Link.String(), s.Clients.Timeouts.Listing, s.Cache)
+	if err != nil {
+		return chapters, err
+	}
+
+	s.Site.Chapters.Each(doc, func(text string, href string) {
+		link, err := s.Site.resolve(href)
+		if err == nil {
+			chapters = append(chapters, Chapter{Name: ReplaceSpecial(text), Link: link})
+		}
+	})
+
+	return
+}
+
+func (s *SiteSource) Pages(ctx context.Context, chapter Chapter) (pages []Page, err error) {
+	doc, err := s.document(ctx, chapter.Link.String(), s.Clients.Timeouts.Page, nil)
+	if err != nil {
+		return pages, err
+	}
+
+	for _, value := range s.Site.Pages.All(doc) {
+		link, err := s.Site.resolve(value)
+		if err == nil {
+			pages = append(pages, Page{PageNo: len(pages) + 1, Link: link})
+		}
+	}
+
+	if len(pages) == 0 {
+		return nil, &ParseError{URL: chapter.Link.String(), Err: errors.New("no pages found")}
+	}
+
+	slog.Debug("listed pages", "chapter", chapter.Name, "pages", len(pages))
+
+	return
+}
+
+func (s *SiteSource) ImageSrc(ctx context.Context, page Page) (*url.URL, error) {
+	doc, err := s.document(ctx, page.Link.String(), s.Clients.Timeouts.Page, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	value, isExist := s.Site.Image.First(doc)
+	if !isExist {
+		return nil, &ParseError{URL: page.Link.String(), Err: errors.New("cannot find img src")}
+	}
+
+	return s.Site.resolve(value)
+}
+
+func (s *SiteSource) Metadata(ctx context.Context, category Category) (*CategoryMetadata, error) {
+	doc, err := s.document(ctx, category.Link.String(), s.Clients.Timeouts.Listing, s.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	categoryImg, ok := s.Site.Metadata.Image.First(doc)
+	if !ok {
+		return nil, &ParseError{URL: category.Link.String(), Err: errors.New("cannot find category img")}
+	}
+	categoryImgUrl, err := s.Site.resolve(categoryImg)
+	if err != nil {
+		return nil, err
+	}
+
+	text := func(selector Selector) string {
+		value, _ := selector.First(doc)
+		return value
+	}
+
+	return &CategoryMetadata{
+		Image:         categoryImgUrl,
+		AltName:       text(s.Site.Metadata.AltName),
+		YearOfRelease: text(s.Site.Metadata.YearOfRelease),
+		Status:        text(s.Site.Metadata.Status),
+		Author:        text(s.Site.Metadata.Author),
+		Artist:        text(s.Site.Metadata.Artist),
+		Description:   text(s.Site.Metadata.Description),
+		Genres:        s.Site.Metadata.Genres.All(doc),
+	}, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSiteSourceAgainstFakeSite(t *testing.T) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alphabetical", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<ul class="series_alpha"><li><a href="/naruto">Naruto</a></li><li><a href="/bleach">Bleach</a></li></ul>`)
+	})
+	mux.HandleFunc("/naruto", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<table id="listing"><tr><td><a href="/naruto/1">Naruto 1</a></td></tr><tr><td><a href="/naruto/2">Naruto 2</a></td></tr></table>`)
+	})
+	mux.HandleFunc("/naruto/1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<select><option value="/naruto/1">1</option><option value="/naruto/1/2">2</option></select>
+			<div id="imgholder"><img id="img" src="/images/naruto-1-1.jpg"></div>`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	site := mangaReaderSite
+	site.Root = server.URL
+
+	s := &SiteSource{Site: &site, Clients: newClientPool(1, FetchTimeouts{})}
+
+	categories, err := s.Categories(context.Background())
+
+	if err != nil || len(categories) != 2 || categories[0].Name != "Naruto" || categories[0].Link.String() != server.URL+"/naruto" {
+		t.Fatal(categories, err)
+	}
+
+	chapters, err := s.Chapters(context.Background(), categories[0])
+
+	if err != nil || len(chapters) != 2 || chapters[1].Name != "Naruto 2" {
+		t.Fatal(chapters, err)
+	}
+
+	pages, err := s.Pages(context.Background(), chapters[0])
+
+	if err != nil || len(pages) != 2 || pages[1].PageNo != 2 || pages[1].Link.String() != server.URL+"/naruto/1/2" {
+		t.Fatal(pages, err)
+	}
+
+	src, err := s.ImageSrc(context.Background(), pages[0])
+
+	if err != nil || src.String() != server.URL+"/images/naruto-1-1.jpg" {
+		t.Error(src, err)
+	}
+}
+
+func TestSiteSourceTimesOutHungPages(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	site := mangaReaderSite
+	site.Root = server.URL
+
+	s := &SiteSource{Site: &site, Clients: newClientPool(1, FetchTimeouts{Page: 50 * time.Millisecond})}
+	s.Clients.Retry = RetryPolicy{MaxAttempts: 2}
+
+	chapter, _ := url.Parse(server.URL + "/naruto/1")
+
+	start := time.Now()
+	_, err := s.Pages(context.Background(), Chapter{Name: "Naruto 1", Link: chapter})
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 2 || !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 2*time.Second {
+		t.Error(time.Since(start), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	s.Clients.Timeouts.Page = 0
+
+	_, err = s.Pages(ctx, Chapter{Name: "Naruto 1", Link: chapter})
+
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 || !errors.Is(err, context.Canceled) {
+		t.Error(err)
+	}
+}
+
+func TestSiteSourceNotFound(t *testing.T) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/naruto/1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head></head><body><h1>404 Not Found</h1></body></html>`)
+	})
+	mux.HandleFunc("/naruto/2", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p>no pages here</p>`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	site := mangaReaderSite
+	site.Root = server.URL
+
+	s := &SiteSource{Site: &site, Clients: newClientPool(1, FetchTimeouts{})}
+
+	for _, path := range []string{"/naruto/1", "/naruto/3"} {
+		link, _ := url.Parse(server.URL + path)
+
+		_, err := s.Pages(context.Background(), Chapter{Link: link})
+
+		if !isNotFound(err, link.String()) {
+			t.Error(path, err)
+		}
+	}
+
+	link, _ := url.Parse(server.URL + "/naruto/2")
+
+	_, err := s.Pages(context.Background(), Chapter{Link: link})
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.URL != link.String() {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestLoadSiteDefinition(t *testing.T) {
+
+	site, err := loadSiteDefinition("sites/mangareader.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(*site, mangaReaderSite) {
+		t.Errorf("sites/mangareader.json does not match the built-in definition: %+v", site)
+	}
+}
+
+func TestSiteDefinitionValidate(t *testing.T) {
+
+	site := mangaReaderSite
+	site.Image = Selector{Selector: "img"}
+
+	if err := site.validate(); err == nil {
+		t.Error("expected error for image selector without attr")
+	}
+
+	site = mangaReaderSite
+	site.Root = "/relative"
+
+	if err := site.validate(); err == nil {
+		t.Error("expected error for relative root")
+	}
+}
+
+func TestSelector(t *testing.T) {
+
+	html := `<ul class="series_alpha"><li><a href="/naruto">Naruto</a></li><li><a href="/bleach">Bleach</a></li><li><a>No link</a></li></ul>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := mangaReaderSite.Categories.All(doc)
+
+	if !reflect.DeepEqual(result, []string{"/naruto", "/bleach"}) {
+		t.Error(result)
+	}
+
+	text, ok := Selector{Selector: "ul li a"}.First(doc)
+
+	if !ok || text != "Naruto" {
+		t.Error(text)
+	}
+
+	_, ok = Selector{Selector: "div#missing"}.First(doc)
+
+	if ok {
+		t.Error("expected no match for missing element")
+	}
+
+	link, err := mangaReaderSite.resolve("/naruto/1")
+
+	if err != nil || link.String() != "http://www.mangareader.net/naruto/1" {
+		t.Error(link, err)
+	}
+}
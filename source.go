@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// Source is a manga site that the job scrapes categories, chapters and pages
+// from. Every method gives up when ctx is done.
+type Source interface {
+	Categories(ctx context.Context) ([]Category, error)
+	Chapters(ctx context.Context, category Category) ([]Chapter, error)
+	Pages(ctx context.Context, chapter Chapter) ([]Page, error)
+	ImageSrc(ctx context.Context, page Page) (*url.URL, error)
+	Metadata(ctx context.Context, category Category) (*CategoryMetadata, error)
+}
+
+// CategoryMetadata is the descriptive information a source has about a category.
+type CategoryMetadata struct {
+	Image         *url.URL
+	AltName       string
+	YearOfRelease string
+	Status        string
+	Author        string
+	Artist        string
+	Description   string
+	Genres        []string
+}
+
+var sources = map[string]func(clients *ClientPool, cache *HTTPCache) Source{
+	"mangareader": func(clients *ClientPool, cache *HTTPCache) Source {
+		return &SiteSource{Site: &mangaReaderSite, Clients: clients, Cache: cache}
+	},
+}
+
+func newSource(name string, clients *ClientPool, cache *HTTPCache) (Source, error) {
+	newFn, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q, available: %v", name, sourceNames())
+	}
+
+	return newFn(clients, cache), nil
+}
+
+func sourceNames() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewSource(t *testing.T) {
+
+	s, err := newSource("mangareader", newClientPool(1, FetchTimeouts{}), nil)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, ok := s.(*SiteSource); !ok {
+		t.Error(s)
+	}
+
+	_, err = newSource("unknown", nil, nil)
+
+	if err == nil {
+		t.Error("expected error for unknown source")
+	}
+}